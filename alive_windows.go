@@ -0,0 +1,28 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "syscall"
+
+const (
+	process_query_limited_information = 0x1000
+	still_active                      = 259
+)
+
+// processAlive reports whether a process with the given pid exists. A
+// process that has exited but whose handle is still held somewhere can be
+// opened, so it has to still be running too.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(process_query_limited_information, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if syscall.GetExitCodeProcess(h, &code) != nil {
+		return false
+	}
+	return code == still_active
+}
@@ -0,0 +1,119 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	assets_dir = flag.String("assets", "", "Fingerprint the static assets in this directory into --assets-out whenever they change")
+	assets_out = flag.String("assets-out", "", "Where fingerprinted assets and their manifest go (default a temporary directory)")
+)
+
+// assetsOut is the directory fingerprinted assets are written to.
+func (s *session) assetsOut() string {
+	if *assets_out != "" {
+		return *assets_out
+	}
+	return filepath.Join(os.TempDir(), "rerun-assets-"+s.binName)
+}
+
+// assetDirs lists the asset directory and everything below it, since file
+// system watches are not recursive.
+func assetDirs() (dirs []string) {
+	if *assets_dir == "" {
+		return
+	}
+	root, err := filepath.Abs(*assets_dir)
+	if err != nil {
+		return
+	}
+	filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			dirs = append(dirs, name)
+		}
+		return nil
+	})
+	return
+}
+
+// isAsset reports whether name lies in the asset tree.
+func isAsset(name string) bool {
+	if *assets_dir == "" {
+		return false
+	}
+	root, err := filepath.Abs(*assets_dir)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(name, root+string(filepath.Separator))
+}
+
+// fingerprintAssets copies every asset to a name containing a hash of its
+// contents, e.g. css/app.css to css/app.3f2a9c1b.css, and writes a
+// manifest.json mapping the original names to the fingerprinted ones.
+func (s *session) fingerprintAssets() (err error) {
+	root, err := filepath.Abs(*assets_dir)
+	if err != nil {
+		return
+	}
+	out := s.assetsOut()
+	err = os.RemoveAll(out)
+	if err != nil {
+		return
+	}
+	if *assets_out == "" {
+		created.add(out)
+	}
+	manifest := map[string]string{}
+	err = filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		rel, _ := filepath.Rel(root, name)
+		ext := filepath.Ext(rel)
+		fingerprinted := strings.TrimSuffix(rel, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+		dst := filepath.Join(out, fingerprinted)
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return err
+		}
+		manifest[filepath.ToSlash(rel)] = filepath.ToSlash(fingerprinted)
+		return ioutil.WriteFile(dst, data, 0644)
+	})
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	return ioutil.WriteFile(filepath.Join(out, "manifest.json"), data, 0644)
+}
+
+// assetEnv tells the program where to find the fingerprinted assets.
+func (s *session) assetEnv() []string {
+	if *assets_dir == "" {
+		return nil
+	}
+	out := s.assetsOut()
+	return []string{
+		"RERUN_ASSETS_DIR=" + out,
+		"RERUN_ASSETS_MANIFEST=" + filepath.Join(out, "manifest.json"),
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// controlClient returns the client and base URL to reach the control API at
+// an address given on the command line, which may be a unix socket path.
+func controlClient(addr string) (client *http.Client, base string) {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return http.DefaultClient, strings.TrimSuffix(addr, "/")
+	}
+	if network(addr) == "unix" {
+		dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}
+		return &http.Client{Transport: &http.Transport{DialContext: dial}}, "http://rerun"
+	}
+	return http.DefaultClient, "http://" + addr
+}
+
+// observe follows another rerun from its control API: it prints the
+// session's status, then its log and the program's output as they come. It
+// only ever reads, so it is safe to point at someone else's session.
+func observe(addr string) (err error) {
+	client, base := controlClient(addr)
+	resp, err := client.Get(base + "/status")
+	if err != nil {
+		return
+	}
+	var st controlStatus
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil {
+		return
+	}
+	fmt.Printf("observing %s: cycle %d", st.Buildpath, st.Cycle)
+	if st.Last != nil {
+		fmt.Printf(", last result %s", st.Last.Result)
+	}
+	if len(st.Failing) > 0 {
+		fmt.Printf(", failing %s", strings.Join(st.Failing, " "))
+	}
+	if u := st.Usage; u != nil {
+		fmt.Printf(", %.1f MB, %.0f%% CPU", float64(u.RSS)/(1<<20), u.CPU)
+	}
+	fmt.Println()
+
+	resp, err = client.Get(base + "/stream")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var l logLine
+		err = dec.Decode(&l)
+		if err != nil {
+			return errors.New("the session went away")
+		}
+		if l.Source == "rerun" {
+			fmt.Println(l.Line)
+		} else {
+			fmt.Printf("%s | %s\n", l.Source, l.Line)
+		}
+	}
+}
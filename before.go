@@ -0,0 +1,165 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var before_cmds stringList
+
+func init() {
+	flag.Var(&before_cmds, "before", "Run this shell command before every build, e.g. \"go generate ./...\", failing the cycle if it fails (repeatable)")
+}
+
+// beforeGrace is how long after the --before commands finish their changes
+// may still arrive.
+const beforeGrace = 100 * time.Millisecond
+
+// generatedCode is the line Go's convention marks generated files with.
+var generatedCode = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// beforeWindow is when the --before commands last ran. The files they
+// generate change while they run, and must not trigger another cycle that
+// runs them again; the edits the user saves meanwhile still must.
+type beforeWindow struct {
+	mu         sync.Mutex
+	start, end time.Time
+	running    bool
+	dropped    map[string]bool   // changed while the commands ran
+	requeued   map[string]string // hand edits queued last time, and their hashes
+}
+
+func (w *beforeWindow) begin() {
+	w.mu.Lock()
+	w.start, w.running = time.Now(), true
+	w.dropped = map[string]bool{}
+	w.mu.Unlock()
+}
+
+func (w *beforeWindow) finish() {
+	w.mu.Lock()
+	w.end, w.running = time.Now(), false
+	w.mu.Unlock()
+}
+
+// during reports whether t falls in the last run of the commands.
+func (w *beforeWindow) during(t time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.start.IsZero() || t.Before(w.start) {
+		return false
+	}
+	return w.running || t.Before(w.end.Add(beforeGrace))
+}
+
+// filter drops the changes made while the commands ran, remembering them
+// for handEdits. Explicit saves are kept.
+func (w *beforeWindow) filter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if ev.Op&watch.Save != 0 || !w.during(ev.Time) {
+			return true
+		}
+		w.mu.Lock()
+		w.dropped[ev.Name] = true
+		w.mu.Unlock()
+		return false
+	})
+}
+
+// handEdits sorts out the dropped changes, once the last of them is in:
+// a file written after the commands finished, or a Go file without the
+// generated code mark, was edited by hand. A hand edit that comes back
+// unchanged the next time is the commands' output after all.
+func (w *beforeWindow) handEdits() (names []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	requeued := map[string]string{}
+	for name := range w.dropped {
+		fi, err := os.Stat(name)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if !fi.ModTime().After(w.end) && (filepath.Ext(name) != ".go" || generated(name)) {
+			continue
+		}
+		sum, err := hashFile(name)
+		if err != nil || w.requeued[name] == sum {
+			continue
+		}
+		requeued[name] = sum
+		names = append(names, name)
+	}
+	w.requeued, w.dropped = requeued, map[string]bool{}
+	return
+}
+
+// generated reports whether the Go file name is marked as generated code.
+func generated(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if generatedCode.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
+
+// requeueHandEdits passes the edits saved while the commands ran on as
+// changes of their own, once the grace period is over.
+func (s *session) requeueHandEdits() {
+	time.AfterFunc(2*beforeGrace, func() {
+		for _, name := range s.before.handEdits() {
+			log.Printf("%s was edited while the --before commands ran", name)
+			s.events <- watch.Event{Name: name, Op: watch.Write, Time: time.Now()}
+		}
+	})
+}
+
+// runBefore runs the --before commands in order, in the package directory,
+// and reports whether all of them succeeded.
+func (s *session) runBefore(rec *cycleRecord, why *cycleReason) (ok bool) {
+	if len(before_cmds) == 0 {
+		return true
+	}
+	s.before.begin()
+	defer s.requeueHandEdits()
+	defer s.before.finish()
+	for _, line := range before_cmds {
+		start := time.Now()
+		log.Printf("running %s", line)
+		cmd := shellCommand(line)
+		cmd.Dir = s.dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Printf("error running %s: %s\n%s", line, err, out)
+			s.step(why, line, start, "failed")
+			rec.finish(s.buildpath, "before failure", string(out))
+			return
+		}
+		s.step(why, line, start, "ok")
+	}
+	return true
+}
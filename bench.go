@@ -0,0 +1,128 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	bench_base  = flag.String("bench", "", "After each successful cycle, compare benchmarks against this git revision")
+	bench_count = flag.Int("bench-count", 5, "How many times to run each benchmark for --bench and rerun bench")
+)
+
+// git runs a git command in dir and returns its trimmed output.
+func git(dir string, args ...string) (out string, err error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	b, err := cmd.CombinedOutput()
+	out = strings.TrimSpace(string(b))
+	if err != nil {
+		err = fmt.Errorf("git %s: %s %s", strings.Join(args, " "), err, out)
+	}
+	return
+}
+
+// benchmark runs the benchmarks of dir and everything below it.
+func benchmark(dir string) (output string, err error) {
+	args := append([]string{"test", "-run", "^$", "-bench", ".", "-benchmem", fmt.Sprintf("-count=%d", *bench_count)}, buildFlags(0)...)
+	cmd := command("go", append(args, "./...")...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// baseBenchmarks runs the benchmarks at revision base in a temporary
+// worktree, so that the working tree is left alone. Results are cached per
+// commit, since they can't change.
+func baseBenchmarks(buildpath, dir, base string) (output string, err error) {
+	root, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return
+	}
+	commit, err := git(dir, "rev-parse", base)
+	if err != nil {
+		return
+	}
+	cache := filepath.Join(stateDir(), "bench", projectKey(buildpath)+"-"+commit+".txt")
+	if b, rerr := ioutil.ReadFile(cache); rerr == nil {
+		return string(b), nil
+	}
+
+	tmp, err := ioutil.TempDir("", "rerun-bench-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	tree := filepath.Join(tmp, "tree")
+	_, err = git(root, "worktree", "add", "--detach", tree, commit)
+	if err != nil {
+		return
+	}
+	defer git(root, "worktree", "remove", "--force", tree)
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return
+	}
+	log.Printf("benchmarking %s at %s", buildpath, base)
+	output, err = benchmark(filepath.Join(tree, rel))
+	if err != nil {
+		return
+	}
+	os.MkdirAll(filepath.Dir(cache), 0755)
+	ioutil.WriteFile(cache, []byte(output), 0644)
+	return
+}
+
+// benchCompare benchmarks the working tree against base and prints the
+// comparison, through benchstat when it is installed.
+func benchCompare(buildpath, base string) (err error) {
+	pkg, err := build.Import(buildpath, "", build.FindOnly)
+	if err != nil {
+		return
+	}
+	old, err := baseBenchmarks(buildpath, pkg.Dir, base)
+	if err != nil {
+		return
+	}
+	log.Printf("benchmarking %s in the working tree", buildpath)
+	cur, err := benchmark(pkg.Dir)
+	if err != nil {
+		return fmt.Errorf("%s\n%s", err, cur)
+	}
+
+	out, err := benchComparison(base, old, "working tree", cur)
+	fmt.Print(out)
+	return
+}
+
+// benchComparison compares two runs of benchmarks through benchstat or, if it
+// is not installed, lists them one after the other.
+func benchComparison(oldName, old, newName, cur string) (out string, err error) {
+	if _, lerr := exec.LookPath("benchstat"); lerr != nil {
+		log.Println("install golang.org/x/perf/cmd/benchstat for a comparison")
+		return fmt.Sprintf("--- %s\n%s\n--- %s\n%s\n", oldName, old, newName, cur), nil
+	}
+	tmp, err := ioutil.TempDir("", "rerun-bench-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	oldPath := filepath.Join(tmp, strings.Replace(oldName, "/", "_", -1))
+	newPath := filepath.Join(tmp, strings.Replace(newName, "/", "_", -1))
+	ioutil.WriteFile(oldPath, []byte(old), 0644)
+	ioutil.WriteFile(newPath, []byte(cur), 0644)
+	b, err := exec.Command("benchstat", oldPath, newPath).CombinedOutput()
+	return string(b), err
+}
@@ -0,0 +1,270 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// A builder is an external build, such as esbuild or tailwind, that runs
+// alongside the go loop: changes to its inputs run its command instead of a
+// go cycle.
+type builder struct {
+	Name    string
+	Inputs  []string // doublestar globs, relative to the current directory
+	Out     string   // where it writes, never one of its inputs
+	Command string
+
+	pending chan bool
+	mu      sync.Mutex
+	running bool
+	ok      bool
+	done    chan bool // closed after the first run
+}
+
+type builderList []*builder
+
+func (l *builderList) String() string {
+	var parts []string
+	for _, b := range *l {
+		parts = append(parts, b.Name)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *builderList) Set(value string) error {
+	i := strings.Index(value, "=")
+	j := strings.Index(value, ":")
+	if i < 0 || j <= 0 || j > i || i == len(value)-1 {
+		return errors.New("expected name:glob[,glob...][>out]=command")
+	}
+	b := &builder{Name: value[:j], Command: value[i+1:]}
+	inputs := value[j+1 : i]
+	if k := strings.Index(inputs, ">"); k >= 0 {
+		inputs, b.Out = inputs[:k], filepath.Clean(inputs[k+1:])
+	}
+	b.Inputs = strings.Split(inputs, ",")
+	*l = append(*l, b)
+	return nil
+}
+
+var (
+	builders     builderList
+	builder_jobs = flag.Int("builder-jobs", 0, "Run at most this many --builder commands at once (default no limit)")
+	builderSlots chan bool
+)
+
+func init() {
+	flag.Var(&builders, "builder", "Run an external build, e.g. esbuild or tailwind, whenever its inputs change, as name:glob[,glob...][>out]=command (repeatable)")
+}
+
+// matches reports whether name, as given by globName, is one of b's inputs
+// and not something it wrote itself.
+func (b *builder) matches(name string) bool {
+	if b.Out != "" && (name == filepath.ToSlash(b.Out) || strings.HasPrefix(name, filepath.ToSlash(b.Out)+"/")) {
+		return false
+	}
+	return matchesAny(b.Inputs, name)
+}
+
+// startBuilders runs every builder once and starts following their inputs.
+func startBuilders(ctx context.Context) {
+	if len(builders) == 0 {
+		return
+	}
+	if *builder_jobs > 0 {
+		builderSlots = make(chan bool, *builder_jobs)
+	}
+	for _, b := range builders {
+		b.pending = make(chan bool, 1)
+		b.done = make(chan bool)
+		b.pending <- true
+		go b.follow(ctx)
+	}
+}
+
+// follow runs the builder for every batch of changes to its inputs.
+func (b *builder) follow(ctx context.Context) {
+	first := true
+	for {
+		select {
+		case <-b.pending:
+		case <-ctx.Done():
+			return
+		}
+		b.mu.Lock()
+		b.running = true
+		b.mu.Unlock()
+		if !first {
+			// let the rest of a save arrive.
+			select {
+			case <-time.After(*debounce):
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-b.pending:
+			default:
+			}
+		}
+		b.run()
+		if first {
+			close(b.done)
+			first = false
+		}
+	}
+}
+
+func (b *builder) run() {
+	if builderSlots != nil {
+		builderSlots <- true
+		defer func() { <-builderSlots }()
+	}
+	if b.Out != "" {
+		os.MkdirAll(b.Out, 0755)
+	}
+	start := time.Now()
+	cmd := shellCommand(b.Command)
+	cmd.Stdout = namedOutput(os.Stdout, b.Name, "stdout")
+	cmd.Stderr = namedOutput(os.Stderr, b.Name, "stderr")
+	err := cmd.Run()
+	if err != nil {
+		log.Printf("builder %s failed: %s", b.Name, err)
+	} else {
+		log.Printf("builder %s: ok in %s", b.Name, time.Since(start).Round(time.Millisecond))
+	}
+	b.mu.Lock()
+	b.running, b.ok = false, err == nil
+	b.mu.Unlock()
+	if err == nil {
+		gate.request()
+	} else {
+		gate.check()
+	}
+}
+
+// trigger asks for another run, unless one is already waiting.
+func (b *builder) trigger() {
+	select {
+	case b.pending <- true:
+	default:
+	}
+}
+
+// ready reports whether b has built successfully and has nothing to do.
+func (b *builder) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ok && !b.running && len(b.pending) == 0
+}
+
+// idle reports whether b is neither running nor about to.
+func (b *builder) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.running && len(b.pending) == 0
+}
+
+// buildersReady reports whether every builder is ready.
+func buildersReady() bool {
+	for _, b := range builders {
+		if !b.ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// waitBuilders waits for the first run of every builder to finish.
+func waitBuilders(ctx context.Context) {
+	for _, b := range builders {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// builderFilter hands changes to a builder's inputs to the builder and drops
+// them: they don't start a go cycle by themselves, what the builder writes
+// may.
+func builderFilter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if len(builders) == 0 || ev.Op&watch.Save != 0 {
+			return true
+		}
+		name := globName(ev.Name)
+		input := false
+		for _, b := range builders {
+			if b.pending != nil && b.matches(name) {
+				b.trigger()
+				input = true
+			}
+		}
+		return !input
+	})
+}
+
+// globRoot is the directory below which files matching glob live: the
+// elements before the first one with a wildcard.
+func globRoot(glob string) (root string, deep bool) {
+	parts := strings.Split(glob, "/")
+	var fixed []string
+	for _, part := range parts[:len(parts)-1] {
+		if strings.ContainsAny(part, "*?[") {
+			deep = true
+			break
+		}
+		fixed = append(fixed, part)
+	}
+	return filepath.FromSlash(strings.Join(fixed, "/")), deep
+}
+
+// builderDirs are the directories holding the builders' inputs.
+func builderDirs() (dirs []string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, b := range builders {
+		for _, glob := range b.Inputs {
+			root, deep := globRoot(glob)
+			root = filepath.Join(wd, root)
+			if !deep {
+				add(root)
+				continue
+			}
+			filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+				if err != nil || !fi.IsDir() {
+					return nil
+				}
+				if name != root && skipDir(name) || b.Out != "" && globName(name) == filepath.ToSlash(b.Out) {
+					return filepath.SkipDir
+				}
+				add(name)
+				return nil
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,60 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"go/build"
+	"strings"
+)
+
+var (
+	build_tags    = flag.String("tags", "", "Build, test and resolve imports with these comma separated build tags")
+	build_ldflags = flag.String("ldflags", "", "Pass these flags to the linker, as go build -ldflags, e.g. for version stamping")
+	build_gcflags = flag.String("gcflags", "", "Pass these flags to the compiler, as go build -gcflags")
+	trimpath      = flag.Bool("trimpath", false, "Build and test with -trimpath")
+	test_flags    argsFlag
+)
+
+func init() {
+	flag.Var(&test_flags, "testflags", "With --test, also pass these flags to go test, e.g. \"-short -count=1 -timeout 30s\"")
+}
+
+// tagArgs are the go command arguments for --tags.
+func tagArgs() []string {
+	if *build_tags == "" {
+		return nil
+	}
+	return []string{"-tags", *build_tags}
+}
+
+// buildFlags are the go command arguments --tags, --ldflags, --gcflags,
+// --debug and --trimpath ask for. A build other than 0 is baked into the
+// binary too; the go command only honours the last -ldflags, so it joins
+// --ldflags.
+func buildFlags(build int) (args []string) {
+	args = tagArgs()
+	ldflags := strings.TrimSpace(*build_ldflags + " " + buildIDLdflag(build))
+	if ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	if *debug_build {
+		args = append(args, debugGcflags()...)
+	} else if *build_gcflags != "" {
+		args = append(args, "-gcflags", *build_gcflags)
+	}
+	if *trimpath {
+		args = append(args, "-trimpath")
+	}
+	return
+}
+
+// useBuildTags makes go/build, which rerun finds packages with, honour --tags.
+func useBuildTags() {
+	if *build_tags == "" {
+		return
+	}
+	build.Default.BuildTags = strings.Split(*build_tags, ",")
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var build_id_var = flag.String("build-id-var", "", "Also set this string variable, e.g. main.buildID, to the build ID with -ldflags -X")
+
+// nextBuildID hands out the next build ID of the project. Unlike cycle
+// numbers, build IDs keep increasing from one session to the next.
+func nextBuildID(buildpath string) (id int) {
+	name := filepath.Join(stateDir(), "build-id", projectKey(buildpath))
+	if data, err := ioutil.ReadFile(name); err == nil {
+		id, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	id++
+	os.MkdirAll(filepath.Dir(name), 0755)
+	ioutil.WriteFile(name, []byte(strconv.Itoa(id)+"\n"), 0644)
+	return
+}
+
+// buildIDLdflag is the linker flag that bakes build into the binary.
+func buildIDLdflag(build int) string {
+	if *build_id_var == "" || build == 0 {
+		return ""
+	}
+	return "-X " + *build_id_var + "=" + strconv.Itoa(build)
+}
+
+// gnuBuildID is the header of the ELF note holding the linker's build ID,
+// which it derives from the Go build ID.
+var gnuBuildID = []byte("\x04\x00\x00\x00\x14\x00\x00\x00\x03\x00\x00\x00GNU\x00")
+
+// sameCode reports whether the build of rec has the code the running one
+// has. --build-id-var makes every binary differ, so then the binaries are
+// compared with what the build IDs change masked out: the values, and the
+// Go and ELF build IDs the linker flags go into.
+func (s *session) sameCode(rec *cycleRecord) bool {
+	if *build_id_var == "" {
+		return rec.Binary == s.runningHash
+	}
+	if s.runningBin == "" {
+		return false
+	}
+	values := []string{strconv.Itoa(s.runningID), strconv.Itoa(rec.Build)}
+	running, err := maskedHash(s.runningBin, values)
+	if err != nil {
+		return false
+	}
+	built, err := maskedHash(s.binPath, values)
+	return err == nil && built == running
+}
+
+// maskedHash hashes the binary name with its build IDs and every occurrence
+// of values zeroed.
+func maskedHash(name string, values []string) (sum [sha256.Size]byte, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	cmd := command("go", "tool", "buildid", name)
+	cmd.Env = installEnv()
+	id, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	for _, v := range append([]string{strings.TrimSpace(string(id))}, values...) {
+		if v != "" {
+			data = bytes.ReplaceAll(data, []byte(v), make([]byte, len(v)))
+		}
+	}
+	if i := bytes.Index(data, gnuBuildID); i >= 0 && i+len(gnuBuildID)+20 <= len(data) {
+		copy(data[i+len(gnuBuildID):i+len(gnuBuildID)+20], make([]byte, 20))
+	}
+	return sha256.Sum256(data), nil
+}
+
+// keepRunning remembers the binary that is about to run, for sameCode.
+// With --build-id-var, that takes a copy: the next build overwrites it.
+func (s *session) keepRunning(rec *cycleRecord) {
+	s.runningHash = rec.Binary
+	if *build_id_var == "" {
+		return
+	}
+	if s.runningBin == "" {
+		s.runningBin = filepath.Join(os.TempDir(), fmt.Sprintf("rerun-running-%s-%d", s.binName, os.Getpid()))
+		created.add(s.runningBin)
+	}
+	if err := copyFile(s.binPath, s.runningBin, 0700); err != nil {
+		log.Printf("error keeping a copy of %s: %s", s.binPath, err)
+		os.Remove(s.runningBin)
+	}
+	s.runningID = rec.Build
+}
+
+// buildIDEnv tells the program which build it runs.
+func buildIDEnv(build int) []string {
+	if build == 0 {
+		return nil
+	}
+	return []string{"RERUN_BUILD_ID=" + strconv.Itoa(build)}
+}
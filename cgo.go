@@ -0,0 +1,101 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// the native sources the go command compiles or links for cgo packages.
+var cgoExts = map[string]bool{
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".cxx": true,
+	".hh": true, ".hpp": true, ".hxx": true, ".m": true, ".s": true,
+	".S": true, ".f": true, ".F": true, ".f90": true, ".syso": true,
+	".swig": true, ".swigcxx": true,
+}
+
+// cgoDirs holds the directories whose native sources feed a cgo package:
+// the package directories themselves and their local include directories.
+var cgoDirs = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: map[string]bool{}}
+
+func addCgoDir(dir string) {
+	cgoDirs.Lock()
+	cgoDirs.dirs[dir] = true
+	cgoDirs.Unlock()
+}
+
+// cgoDirList returns the cgo directories found so far.
+func cgoDirList() (dirs []string) {
+	cgoDirs.Lock()
+	defer cgoDirs.Unlock()
+	for dir := range cgoDirs.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return
+}
+
+// cgoFilter keeps changes to native sources in cgo directories.
+func cgoFilter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if !cgoExts[filepath.Ext(ev.Name)] {
+			return false
+		}
+		cgoDirs.Lock()
+		defer cgoDirs.Unlock()
+		return cgoDirs.dirs[filepath.Dir(ev.Name)]
+	})
+}
+
+// cgoIncludeDirs returns the directories named with -I in the package's
+// #cgo flags that live in a GOPATH workspace or below the package itself,
+// leaving out system headers.
+func cgoIncludeDirs(pkg *build.Package) (dirs []string) {
+	var flags []string
+	flags = append(flags, pkg.CgoCFLAGS...)
+	flags = append(flags, pkg.CgoCPPFLAGS...)
+	flags = append(flags, pkg.CgoCXXFLAGS...)
+	for i, f := range flags {
+		var dir string
+		switch {
+		case f == "-I" && i+1 < len(flags):
+			dir = flags[i+1]
+		case strings.HasPrefix(f, "-I") && len(f) > 2:
+			dir = f[2:]
+		default:
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(pkg.Dir, dir)
+		}
+		dir = filepath.Clean(dir)
+		if isLocal(dir, pkg.Dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return
+}
+
+// isLocal reports whether dir belongs to the user's code rather than the
+// system: below pkgDir or in a GOPATH workspace.
+func isLocal(dir, pkgDir string) bool {
+	roots := append([]string{pkgDir}, filepath.SplitList(build.Default.GOPATH)...)
+	for _, root := range roots {
+		if root != "" && (dir == root || strings.HasPrefix(dir, root+string(filepath.Separator))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var (
+	lead_addr   = flag.String("lead", "", "Serve this instance's rebuild decisions on this address, a unix socket path or host:port, to instances started with --follow; the --daemon address has the daemon relay them")
+	follow_addr = flag.String("follow", "", "Rebuild when the rerun instance started with --lead at this address, or the daemon relaying its decisions, decides to, instead of watching files")
+)
+
+// Chained instances speak the daemon protocol. A follower sends
+//
+//	follow
+//
+// and receives every decision of the leader to rebuild as
+//
+//	change <file>
+//	rebuild
+//
+// one change line for each file in the batch, after the leader's filters
+// and debouncing. A leader serves followers itself or, when --lead is the
+// --daemon address, sends the daemon
+//
+//	lead
+//
+// and then its decisions, for the daemon to relay.
+
+// followers are the instances following this one's decisions.
+type followers struct {
+	mu  sync.Mutex
+	out map[chan string]bool
+}
+
+// lead starts passing this instance's decisions on to the instances that
+// follow addr.
+func lead(addr string) (*followers, error) {
+	if addr == *daemon_addr {
+		return leadThroughDaemon(addr), nil
+	}
+	return serveFollowers(addr)
+}
+
+// leadThroughDaemon has the daemon at addr relay the decisions, redialing
+// it whenever the connection is lost.
+func leadThroughDaemon(addr string) *followers {
+	out := make(chan string, 256)
+	go func() {
+		var conn net.Conn
+		for msg := range out {
+			if conn == nil {
+				c, err := net.DialTimeout(network(addr), addr, 5*time.Second)
+				if err == nil {
+					_, err = fmt.Fprintln(c, "lead")
+				}
+				if err != nil {
+					log.Printf("error leading through %s: %s; dropped a rebuild", addr, err)
+					if c != nil {
+						c.Close()
+					}
+					continue
+				}
+				conn = c
+			}
+			if _, err := fmt.Fprint(conn, msg); err != nil {
+				log.Printf("lost %s: %s; dropped a rebuild", addr, err)
+				conn.Close()
+				conn = nil
+			}
+		}
+	}()
+	log.Printf("leading instances that --follow %s through the daemon", addr)
+	return &followers{out: map[chan string]bool{out: true}}
+}
+
+func serveFollowers(addr string) (f *followers, err error) {
+	nw := network(addr)
+	if nw == "unix" {
+		os.MkdirAll(filepath.Dir(addr), 0755)
+		os.Remove(addr)
+	}
+	ln, err := net.Listen(nw, addr)
+	if err != nil {
+		return
+	}
+	if nw == "unix" {
+		created.add(addr)
+	}
+	f = &followers{out: map[chan string]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("error accepting followers: %s", err)
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	log.Printf("leading instances that --follow %s", addr)
+	return
+}
+
+func (f *followers) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() || scanner.Text() != "follow" {
+		return
+	}
+	out := make(chan string, 256)
+	f.mu.Lock()
+	f.out[out] = true
+	f.mu.Unlock()
+	log.Printf("%s follows", conn.RemoteAddr())
+	go func() {
+		// the follower says nothing more; notice when it hangs up.
+		for scanner.Scan() {
+		}
+		f.mu.Lock()
+		delete(f.out, out)
+		f.mu.Unlock()
+		close(out)
+	}()
+	for msg := range out {
+		if _, err := fmt.Fprint(conn, msg); err != nil {
+			break
+		}
+	}
+	log.Printf("%s stopped following", conn.RemoteAddr())
+}
+
+// decided tells the followers to rebuild for the changed files.
+func (f *followers) decided(changed []string) {
+	if f == nil {
+		return
+	}
+	var msg strings.Builder
+	for _, name := range changed {
+		fmt.Fprintf(&msg, "change %s\n", name)
+	}
+	msg.WriteString("rebuild\n")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for out := range f.out {
+		select {
+		case out <- msg.String():
+		default:
+			log.Printf("a follower is not keeping up, dropped a rebuild")
+		}
+	}
+}
+
+// follow takes the decisions of the leader at addr as batches, redialing
+// whenever the connection is lost, until the session ends.
+func follow(addr string) <-chan []watch.Event {
+	batches := make(chan []watch.Event)
+	go func() {
+		lost := false
+		for {
+			conn, err := net.DialTimeout(network(addr), addr, 5*time.Second)
+			if err == nil {
+				_, err = fmt.Fprintln(conn, "follow")
+			}
+			if err != nil {
+				if !lost {
+					log.Printf("error following %s: %s; retrying", addr, err)
+				}
+				lost = true
+				if conn != nil {
+					conn.Close()
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+			log.Printf("following %s", addr)
+			lost = false
+			var batch []watch.Event
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case strings.HasPrefix(line, "change "):
+					// the leader's filters had their say: take it at its word.
+					batch = append(batch, watch.Event{Name: strings.TrimPrefix(line, "change "), Op: watch.Save, Time: time.Now()})
+				case line == "rebuild":
+					if len(batch) > 0 {
+						batches <- batch
+					}
+					batch = nil
+				}
+			}
+			conn.Close()
+			log.Printf("lost %s; reconnecting", addr)
+			lost = true
+		}
+	}()
+	return batches
+}
@@ -0,0 +1,260 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A checkpoint is a named snapshot of the program that later cycles can be
+// compared against, say before a refactor.
+type checkpoint struct {
+	Name     string    `json:"name"`
+	Time     time.Time `json:"time"`
+	Cycle    int       `json:"cycle"`
+	Build    int       `json:"build,omitempty"`
+	Binary   string    `json:"binary"`
+	Size     int64     `json:"size"`
+	Coverage float64   `json:"coverage"` // percent of statements, -1 if unknown
+	Bench    string    `json:"bench,omitempty"`
+}
+
+// checkpointName keeps names usable as file names.
+var checkpointName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func checkpointDir(buildpath string) string {
+	return filepath.Join(stateDir(), "checkpoints", projectKey(buildpath))
+}
+
+var coverageLine = regexp.MustCompile(`coverage: ([0-9.]+)% of statements`)
+
+// coverage runs the tests of buildpath with -cover and returns the share of
+// statements they cover.
+func coverage(buildpath string) (percent float64, err error) {
+	args := append([]string{"test", "-cover"}, buildFlags(0)...)
+	cmd := command("go", append(args, buildpath)...)
+	cmd.Env = installEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return -1, fmt.Errorf("%s\n%s", err, out)
+	}
+	m := coverageLine.FindSubmatch(out)
+	if m == nil {
+		return -1, nil
+	}
+	return strconv.ParseFloat(string(m[1]), 64)
+}
+
+// measure takes the measurements of the current build that checkpoints keep.
+func (s *session) measure(name string) (cp checkpoint, err error) {
+	st := s.loopStatus()
+	cp = checkpoint{Name: name, Time: time.Now(), Cycle: st.Cycle, Build: st.Build}
+	cp.Binary, err = hashFile(s.binPath)
+	if err != nil {
+		return
+	}
+	if fi, serr := os.Stat(s.binPath); serr == nil {
+		cp.Size = fi.Size()
+	}
+	if noBuild() {
+		cp.Coverage = -1
+		return
+	}
+	log.Printf("measuring coverage of %s", s.buildpath)
+	if cp.Coverage, err = coverage(s.buildpath); err != nil {
+		return
+	}
+	log.Printf("benchmarking %s", s.buildpath)
+	cp.Bench, err = benchmark(s.dir)
+	if err != nil {
+		err = fmt.Errorf("%s\n%s", err, cp.Bench)
+	}
+	return
+}
+
+// takeCheckpoint measures the current build and keeps it, along with a copy
+// of the binary, under name.
+func (s *session) takeCheckpoint(name string) (cp checkpoint, err error) {
+	if !checkpointName.MatchString(name) {
+		return cp, fmt.Errorf("bad checkpoint name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	cp, err = s.measure(name)
+	if err != nil {
+		return
+	}
+	dir := checkpointDir(s.buildpath)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	fi, err := os.Stat(s.binPath)
+	if err != nil {
+		return
+	}
+	if err = copyFile(s.binPath, filepath.Join(dir, name+".bin"), fi.Mode()); err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+".json"), data, 0644)
+	if err == nil {
+		log.Printf("checkpoint %s taken at cycle %d", name, cp.Cycle)
+	}
+	return
+}
+
+func loadCheckpoint(buildpath, name string) (cp checkpoint, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(checkpointDir(buildpath), name+".json"))
+	if os.IsNotExist(err) {
+		return cp, fmt.Errorf("no checkpoint %q", name)
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &cp)
+	return
+}
+
+// listCheckpoints returns the checkpoints of the project, oldest first.
+func listCheckpoints(buildpath string) (cps []checkpoint) {
+	names, _ := filepath.Glob(filepath.Join(checkpointDir(buildpath), "*.json"))
+	for _, name := range names {
+		if cp, err := loadCheckpoint(buildpath, strings.TrimSuffix(filepath.Base(name), ".json")); err == nil {
+			cps = append(cps, cp)
+		}
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].Time.Before(cps[j].Time) })
+	return
+}
+
+// compareCheckpoint measures the current build and reports how it differs
+// from the checkpoint name.
+func (s *session) compareCheckpoint(name string) (report string, err error) {
+	old, err := loadCheckpoint(s.buildpath, name)
+	if err != nil {
+		return
+	}
+	cur, err := s.measure("current")
+	if err != nil {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "checkpoint %s (cycle %d, %s) against cycle %d:\n", old.Name, old.Cycle, old.Time.Format("2006-01-02 15:04"), cur.Cycle)
+	if old.Binary == cur.Binary {
+		fmt.Fprintf(&b, "  binary: unchanged\n")
+	} else {
+		fmt.Fprintf(&b, "  binary: changed\n")
+	}
+	fmt.Fprintf(&b, "  size: %d -> %d bytes (%+d)\n", old.Size, cur.Size, cur.Size-old.Size)
+	if old.Coverage >= 0 && cur.Coverage >= 0 {
+		fmt.Fprintf(&b, "  coverage: %.1f%% -> %.1f%% (%+.1f)\n", old.Coverage, cur.Coverage, cur.Coverage-old.Coverage)
+	}
+	if old.Bench != "" && cur.Bench != "" {
+		bench, berr := benchComparison(old.Name, old.Bench, "current", cur.Bench)
+		if berr != nil {
+			log.Printf("comparing benchmarks: %s", berr)
+		}
+		b.WriteString(bench)
+	}
+	return b.String(), nil
+}
+
+// serveCheckpoints serves the checkpoints of s:
+//
+//	GET /checkpoints	the checkpoints of the project
+//	POST /checkpoints?name=	take a checkpoint of the current build
+//	POST /checkpoints/diff?name=	compare the current build against a checkpoint
+func (s *session) serveCheckpoints(mux *http.ServeMux) {
+	mux.HandleFunc("/checkpoints", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeJSON(w, listCheckpoints(s.buildpath))
+			return
+		}
+		cp, err := s.takeCheckpoint(r.FormValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, cp)
+	})
+	mux.HandleFunc("/checkpoints/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := s.compareCheckpoint(r.FormValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Print(report)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, report)
+	})
+}
+
+// checkpointCommand is the rerun checkpoint command, which asks a running
+// session to take a checkpoint or to compare against one.
+func checkpointCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("checkpoint", flag.ExitOnError)
+	control := fs.String("control", "localhost:8787", "The --http-control address or socket of the session")
+	diff := fs.Bool("diff", false, "Compare the current build against the checkpoint instead of taking it")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rerun checkpoint [-control addr] [-diff] <name>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := "/checkpoints"
+	if *diff {
+		path += "/diff"
+	}
+	client, base := controlClient(*control)
+	resp, err := client.PostForm(base+path, url.Values{"name": {fs.Arg(0)}})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+	if *diff {
+		fmt.Print(string(body))
+		return
+	}
+	var cp checkpoint
+	if err = json.NewDecoder(bytes.NewReader(body)).Decode(&cp); err != nil {
+		return
+	}
+	fmt.Printf("checkpoint %s taken at cycle %d: %d bytes", cp.Name, cp.Cycle, cp.Size)
+	if cp.Coverage >= 0 {
+		fmt.Printf(", %.1f%% coverage", cp.Coverage)
+	}
+	fmt.Println()
+	return
+}
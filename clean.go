@@ -0,0 +1,101 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var clean_on_exit = flag.Bool("clean", true, "Remove the binaries and temporary files rerun created when it exits")
+
+// artifacts are the files rerun created and should not leave behind. They
+// are also listed in a file named after rerun's PID, so that rerun clean can
+// find them after a session crashed.
+type artifacts struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+var created = &artifacts{}
+
+func artifactsPath(pid int) string {
+	return filepath.Join(stateDir(), "artifacts", strconv.Itoa(pid)+".json")
+}
+
+// add records path as created by rerun.
+func (a *artifacts) add(path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.paths {
+		if p == path {
+			return
+		}
+	}
+	a.paths = append(a.paths, path)
+	data, _ := json.Marshal(a.paths)
+	name := artifactsPath(os.Getpid())
+	os.MkdirAll(filepath.Dir(name), 0755)
+	ioutil.WriteFile(name, data, 0644)
+}
+
+// remove deletes everything recorded.
+func (a *artifacts) remove() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.paths {
+		log.Printf("removing %s", p)
+		os.RemoveAll(p)
+	}
+	a.paths = nil
+	os.Remove(artifactsPath(os.Getpid()))
+}
+
+// clean removes what sessions that are no longer running left behind: the
+// artifacts they recorded and their PID files. It returns how many files it
+// removed.
+func clean() (n int) {
+	names, _ := filepath.Glob(filepath.Join(stateDir(), "artifacts", "*.json"))
+	for _, name := range names {
+		pid, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil || processAlive(pid) {
+			continue
+		}
+		var paths []string
+		data, err := ioutil.ReadFile(name)
+		if err == nil {
+			json.Unmarshal(data, &paths)
+		}
+		for _, p := range paths {
+			if _, err := os.Stat(p); err != nil {
+				continue
+			}
+			fmt.Printf("removing %s (left by pid %d)\n", p, pid)
+			if os.RemoveAll(p) == nil {
+				n++
+			}
+		}
+		os.Remove(name)
+	}
+	pids, _ := filepath.Glob(filepath.Join(stateDir(), "run", "*.pid"))
+	for _, name := range pids {
+		if pid, ok := readPID(name); ok && processAlive(pid) {
+			continue
+		}
+		fmt.Printf("removing stale PID file %s\n", name)
+		if os.Remove(name) == nil {
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,336 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var config_file = flag.String("config", ".rerun.toml", "Read settings from this file if it exists; command line flags take precedence")
+
+// fromConfig holds the settings the configuration file gave.
+var fromConfig = map[string]bool{}
+
+// A configValue is a single value or, for repeatable flags and args, a list.
+type configValue struct {
+	list   bool
+	values []string
+}
+
+// parseConfig reads the subset of TOML rerun's configuration needs:
+// key = value lines, where a value is a string, a boolean, a number or an
+// array of those, and # comments. Keys are flag names, plus path for the
+// import path and args for the program's arguments.
+func parseConfig(data []byte) (settings map[string]configValue, keys []string, err error) {
+	settings = map[string]configValue{}
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := stripComment(lines[n])
+		if line == "" {
+			continue
+		}
+		lineno := n + 1
+		if strings.HasPrefix(line, "[") {
+			return nil, nil, fmt.Errorf("line %d: tables are not supported", lineno)
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("line %d: expected key = value", lineno)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		value := strings.TrimSpace(line[i+1:])
+		// arrays may span lines.
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && n+1 < len(lines) {
+			n++
+			value += " " + stripComment(lines[n])
+		}
+		var v configValue
+		v, err = parseValue(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		if _, dup := settings[key]; dup {
+			return nil, nil, fmt.Errorf("line %d: %s is set twice", lineno, key)
+		}
+		settings[key] = v
+		keys = append(keys, key)
+	}
+	return
+}
+
+// stripComment removes a # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote && (i == 0 || line[i-1] != '\\' || quote == '\''):
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func parseValue(value string) (v configValue, err error) {
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") {
+			return v, fmt.Errorf("unterminated array")
+		}
+		v.list = true
+		for _, item := range splitArray(value[1 : len(value)-1]) {
+			s, err := parseScalar(item)
+			if err != nil {
+				return v, err
+			}
+			v.values = append(v.values, s)
+		}
+		return
+	}
+	s, err := parseScalar(value)
+	v.values = []string{s}
+	return
+}
+
+// splitArray splits the inside of an array at the commas between items.
+func splitArray(s string) (items []string) {
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0 && c == quote && (i == 0 || s[i-1] != '\\' || quote == '\''):
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return
+}
+
+func parseScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s == "true", s == "false":
+		return s, nil
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return "", fmt.Errorf("expected a string, boolean or number, got %s", s)
+	}
+	return s, nil
+}
+
+// loadConfig applies the configuration file to every flag that was not
+// given on the command line, and returns the import path and arguments it
+// sets, if any.
+func loadConfig() (buildpath string, args []string, err error) {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	data, err := ioutil.ReadFile(*config_file)
+	if os.IsNotExist(err) && !explicit["config"] {
+		return "", nil, nil
+	}
+	if err != nil {
+		return
+	}
+	settings, keys, err := parseConfig(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %s", *config_file, err)
+	}
+	for _, key := range keys {
+		v := settings[key]
+		switch key {
+		case "path":
+			if v.list {
+				return "", nil, fmt.Errorf("%s: path must be a string", *config_file)
+			}
+			buildpath = v.values[0]
+			fromConfig[key] = true
+			continue
+		case "args":
+			args = v.values
+			fromConfig[key] = true
+			continue
+		case "config":
+			return "", nil, fmt.Errorf("%s: config can't be set from a configuration file", *config_file)
+		}
+		if flag.Lookup(key) == nil {
+			return "", nil, fmt.Errorf("%s: unknown setting %s", *config_file, key)
+		}
+		if explicit[key] {
+			continue
+		}
+		for _, value := range v.values {
+			if err = flag.Set(key, value); err != nil {
+				return "", nil, fmt.Errorf("%s: %s: %s", *config_file, key, err)
+			}
+		}
+		fromConfig[key] = true
+	}
+	log.Printf("read settings from %s", *config_file)
+	return
+}
+
+// A setting is a flag's effective value, in .rerun.toml syntax, and where it
+// came from: the command line, the configuration file or the default.
+type setting struct {
+	Name, Value, Source string
+}
+
+// settings lists every flag, in order.
+func settings() (list []setting) {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		source := "default"
+		switch {
+		case fromConfig[f.Name]:
+			source = *config_file
+		case given[f.Name]:
+			source = "command line"
+		}
+		list = append(list, setting{f.Name, tomlValue(f.Value), source})
+	})
+	return
+}
+
+// tomlValue writes a flag's value the way the configuration file takes it.
+func tomlValue(v flag.Value) string {
+	var list []string
+	switch l := v.(type) {
+	case *stringList:
+		list = *l
+	case *windowList, keyMap, stageLabelList:
+		if s := v.String(); s != "" {
+			list = strings.Split(s, ",")
+		}
+	default:
+		s := v.String()
+		if _, err := strconv.ParseFloat(s, 64); err == nil || s == "true" || s == "false" {
+			return s
+		}
+		return strconv.Quote(s)
+	}
+	var items []string
+	for _, s := range list {
+		items = append(items, strconv.Quote(s))
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// watchRoots reduces dirs to those not inside another one of them.
+func watchRoots(dirs []string) (roots []string) {
+	sorted := append([]string(nil), dirs...)
+	sort.Strings(sorted)
+	for _, dir := range sorted {
+		if n := len(roots); n > 0 && (dir == roots[n-1] || strings.HasPrefix(dir, roots[n-1]+string(filepath.Separator))) {
+			continue
+		}
+		roots = append(roots, dir)
+	}
+	return
+}
+
+// logSettings logs the settings that aren't defaults, to show which of the
+// command line and the configuration file won.
+func logSettings() {
+	var b strings.Builder
+	for _, st := range settings() {
+		if st.Source != "default" {
+			fmt.Fprintf(&b, "\n\t%s = %s (%s)", st.Name, st.Value, st.Source)
+		}
+	}
+	if b.Len() == 0 {
+		log.Print("settings: all defaults")
+	} else {
+		log.Printf("settings:%s", b.String())
+	}
+}
+
+// logWatching logs where the watched directories are.
+func (s *session) logWatching() {
+	if s.watching == nil {
+		// the daemon watches.
+		return
+	}
+	var dirs []string
+	for dir := range s.watching {
+		dirs = append(dirs, dir)
+	}
+	log.Printf("watching %d directories under %s", len(dirs), strings.Join(watchRoots(dirs), ", "))
+}
+
+// configCommand is rerun config show, which prints the effective settings
+// for the flags before it and, given an import path or read from the
+// configuration file, what would be watched.
+func configCommand(args []string) (err error) {
+	if len(args) == 0 || args[0] != "show" {
+		return errors.New("Usage: rerun [flags] config show [import path] [arg]*")
+	}
+	buildpath, progArgs, err := loadConfig()
+	if err != nil {
+		return
+	}
+	useBuildTags()
+	pathSource, argsSource := *config_file, *config_file
+	if len(args) > 1 {
+		buildpath, progArgs = args[1], args[2:]
+		pathSource, argsSource = "command line", "command line"
+	}
+	if buildpath == "" && *exec_cmd != "" {
+		buildpath, pathSource = *exec_cmd, "exec"
+	}
+	if buildpath != "" {
+		fmt.Printf("path = %s # %s\n", strconv.Quote(buildpath), pathSource)
+	}
+	if len(progArgs) > 0 {
+		l := stringList(progArgs)
+		fmt.Printf("args = %s # %s\n", tomlValue(&l), argsSource)
+	}
+	for _, st := range settings() {
+		fmt.Printf("%s = %s # %s\n", st.Name, st.Value, st.Source)
+	}
+	if buildpath == "" {
+		return
+	}
+	s := &session{buildpath: buildpath, graph: depGraph{}}
+	if err = s.locate(); err != nil {
+		return
+	}
+	dirs := s.watchDirs()
+	fmt.Printf("# watching %d directories under:\n", len(dirs))
+	for _, root := range watchRoots(dirs) {
+		fmt.Printf("#\t%s\n", root)
+	}
+	return
+}
@@ -0,0 +1,69 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	container_image   = flag.String("containerize", "", "Run the program inside a container from this image")
+	container_runtime = flag.String("container-runtime", "", "Container runtime for --containerize: docker or podman (default whichever is installed)")
+	publish           stringList
+)
+
+func init() {
+	flag.Var(&publish, "publish", "Publish a container port with --containerize, as host:container (repeatable)")
+}
+
+// runtimeName picks the container runtime to use.
+func runtimeName() string {
+	if *container_runtime != "" {
+		return *container_runtime
+	}
+	if _, err := exec.LookPath("docker"); err == nil {
+		return "docker"
+	}
+	return "podman"
+}
+
+// containerName is unique per session, so that a container left over from
+// a killed child can be removed by name.
+func (s *session) containerName() string {
+	return fmt.Sprintf("rerun-%s-%d", s.binName, os.Getpid())
+}
+
+// containerCommand runs the freshly built binary inside a container, with
+// the package directory mounted at the same path and used as working
+// directory, and the requested ports published.
+func (s *session) containerCommand() *exec.Cmd {
+	bin := "/rerun/" + s.binName
+	args := []string{"run", "--rm", "--name", s.containerName(),
+		"-v", s.dir + ":" + s.dir, "-w", s.dir,
+		"-v", s.binPath + ":" + bin + ":ro",
+	}
+	for _, p := range publish {
+		args = append(args, "-p", p)
+	}
+	args = append(args, containerCredentials()...)
+	args = append(args, *container_image, bin)
+	args = append(args, s.args...)
+	return command(runtimeName(), args...)
+}
+
+// removeContainer makes sure the container is gone, even when the runtime's
+// client was killed before it could clean up. Usually --rm already took care
+// of it, so a missing container is not an error.
+func (s *session) removeContainer() {
+	out, err := exec.Command(runtimeName(), "rm", "-f", s.containerName()).CombinedOutput()
+	if err != nil && !strings.Contains(strings.ToLower(string(out)), "no such container") {
+		log.Printf("error removing container %s: %s %s", s.containerName(), err, out)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+var (
+	http_control = flag.String("http-control", "", "Serve rerun's control API over HTTP on this address, e.g. localhost:8787, or on this unix socket path")
+	http_observe = flag.String("http-observe", "", "Serve a read-only control API on this address or unix socket path, for rerun attach --observe")
+)
+
+// serving reports whether any control API is up.
+func serving() bool {
+	return *http_control != "" || *http_observe != ""
+}
+
+// controlStatus is a summary of the session.
+type controlStatus struct {
+	Buildpath string
+	Binary    string
+	Cycle     int
+	Build     int
+	Last      *cycleRecord
+	Failing   []string
+	Failures  int
+	Queue     queueStatus
+	Usage     *procUsage `json:",omitempty"`
+}
+
+// A loopStatus is the part of the session the build loop owns. The loop
+// publishes a copy after every cycle, and the API reads that rather than
+// fields the next cycle is changing.
+type loopStatus struct {
+	Cycle    int
+	Build    int
+	Last     *cycleRecord
+	Failing  []string
+	Failures int
+}
+
+// publishStatus makes the state of the cycle that just ended visible to the
+// API.
+func (s *session) publishStatus() {
+	st := loopStatus{Cycle: s.cycle, Build: s.buildID, Failing: s.failing, Failures: s.failures}
+	if s.last != nil {
+		last := *s.last
+		st.Last = &last
+	}
+	s.status.Store(st)
+}
+
+// loopStatus returns what the loop published last.
+func (s *session) loopStatus() loopStatus {
+	st, _ := s.status.Load().(loopStatus)
+	return st
+}
+
+// serveControl serves the control API for s:
+//
+//	GET /status	a summary of the session
+//	POST /rebuild	run a cycle, restarting the program if its binary changed
+//	POST /restart	rebuild and restart the program even if nothing changed
+//	POST /stop	stop the program until the next cycle starts it again
+//	GET /stream	rerun's log and the program's output, as JSON lines
+//	GET /search?re=&cycle=&build=&since=&until=	search the program's output (see rerun search)
+//	GET /reasons	the causal chains of recent cycles
+//	GET /processes	recent starts and stops of the program
+//	GET /queue	the running cycle and the changes waiting for the next one
+//	POST /queue/drop	discard the waiting changes
+//	POST /queue/flush	start the next cycle without waiting for --rate-limit
+//	GET /labels, POST /labels/...	turn labelled stages, rules and triggers on and off (see serveLabels)
+//	GET /tests, POST /tests/focus	run a single test (see serveFocus)
+//	GET /output, POST /output/...	mute, solo and filter the program's output (see serveView)
+//	GET /checkpoints, POST /checkpoints...	take and compare against checkpoints (see serveCheckpoints)
+//	GET /debug/vars	rerun's own counters, as expvar
+//	/debug/pprof/	rerun's own profiles, left out of a read-only API
+//
+// With several programs, each one's API is under /<name>/, and GET / lists
+// them. A read-only API refuses everything but GET.
+func serveControl(addr string, sessions []*session, readOnly bool) {
+	var h http.Handler
+	if len(sessions) == 1 {
+		h = controlMux(sessions[0], readOnly)
+	} else {
+		root := http.NewServeMux()
+		var names []string
+		for _, s := range sessions {
+			names = append(names, s.name)
+			root.Handle("/"+s.name+"/", http.StripPrefix("/"+s.name, controlMux(s, readOnly)))
+		}
+		root.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, names)
+		})
+		h = root
+	}
+	kind := "control API"
+	if readOnly {
+		h = getOnly(h)
+		kind = "read-only control API"
+	}
+	nw := network(addr)
+	if nw == "unix" {
+		// a socket left behind by an earlier session.
+		os.Remove(addr)
+	}
+	ln, err := net.Listen(nw, addr)
+	if err != nil {
+		log.Printf("%s: %s", kind, err)
+		return
+	}
+	if nw == "unix" {
+		os.Chmod(addr, 0600)
+		log.Printf("%s on unix socket %s", kind, addr)
+	} else {
+		log.Printf("%s on http://%s", kind, addr)
+	}
+	err = http.Serve(ln, h)
+	if err != nil {
+		log.Printf("%s: %s", kind, err)
+	}
+}
+
+// controlMux serves the control API of one session.
+func controlMux(s *session, readOnly bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		st := s.loopStatus()
+		writeJSON(w, controlStatus{
+			Buildpath: s.buildpath,
+			Binary:    s.binPath,
+			Cycle:     st.Cycle,
+			Build:     st.Build,
+			Last:      st.Last,
+			Failing:   st.Failing,
+			Failures:  st.Failures,
+			Queue:     s.queue.status(),
+			Usage:     s.usage.get(),
+		})
+	})
+	mux.HandleFunc("/rebuild", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		log.Println("rebuild requested")
+		s.cycleRequested()
+	}))
+	mux.HandleFunc("/restart", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		log.Println("restart requested")
+		s.restartRequested()
+	}))
+	mux.HandleFunc("/stop", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		log.Println("stop requested")
+		if !s.stopRequested() {
+			http.Error(w, "the program isn't run", http.StatusConflict)
+		}
+	}))
+	mux.HandleFunc("/stream", s.streamLogs)
+	mux.HandleFunc("/search", s.serveSearch)
+	mux.HandleFunc("/reasons", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.reasons.recent())
+	})
+	mux.HandleFunc("/processes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.procs.recent())
+	})
+	mux.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.queue.status())
+	})
+	mux.HandleFunc("/queue/drop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		n := s.queue.drop()
+		log.Printf("dropped %d queued change(s)", n)
+		writeJSON(w, s.queue.status())
+	})
+	mux.HandleFunc("/queue/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		s.queue.flush()
+		writeJSON(w, s.queue.status())
+	})
+	serveView(mux)
+	s.serveFocus(mux)
+	s.serveCheckpoints(mux)
+	serveLabels(mux)
+	debugHandlers(mux, readOnly)
+	s.publish()
+	return mux
+}
+
+// postOnly refuses anything but POST.
+func postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// getOnly refuses every request that could change something.
+func getOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "this control API is read-only", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// streamLogs sends the recent lines of the log hub and then follows it,
+// until the client goes away.
+func (s *session) streamLogs(w http.ResponseWriter, r *http.Request) {
+	recent, lines, cancel := logs.subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, l := range recent {
+		if s.owns(l) {
+			enc.Encode(l)
+		}
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case l := <-lines:
+			if !s.owns(l) {
+				continue
+			}
+			if enc.Encode(l) != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// owns reports whether l belongs on the API of s: with several programs,
+// only the lines of its own program do.
+func (s *session) owns(l logLine) bool {
+	return s.name == "" || l.Process == s.binName
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
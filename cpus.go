@@ -0,0 +1,55 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var build_cpus = flag.String("build-cpus", "", "While the program runs, limit the go toolchain to this many CPUs, or auto for half of them")
+
+// buildCPUs is how many CPUs the toolchain may use right now, or 0 for no
+// limit. The limit only applies while the program is running, so that
+// rebuilds don't starve it.
+func buildCPUs() (n int) {
+	if *build_cpus == "" {
+		return 0
+	}
+	children.Lock()
+	running := len(children.procs) > 0
+	children.Unlock()
+	if !running {
+		return 0
+	}
+	if *build_cpus == "auto" {
+		n = runtime.NumCPU() / 2
+	} else if v, err := strconv.Atoi(*build_cpus); err == nil {
+		n = v
+	} else {
+		log.Printf("--build-cpus: expected a number or auto, got %q", *build_cpus)
+		return 0
+	}
+	if n < 1 {
+		n = 1
+	}
+	return
+}
+
+// throttled limits the parallelism of the go command in env, both the
+// number of packages built at once and the compiler's own threads.
+func throttled(env []string) []string {
+	n := buildCPUs()
+	if n == 0 {
+		return env
+	}
+	goflags := strings.TrimSpace(fmt.Sprintf("-p=%d %s", n, os.Getenv("GOFLAGS")))
+	return append(env, fmt.Sprintf("GOMAXPROCS=%d", n), "GOFLAGS="+goflags)
+}
@@ -0,0 +1,120 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// credentials are the environment variables that grant access to something,
+// by kind. The program doesn't get them unless asked for with
+// --pass-credentials.
+var credentials = map[string][]string{
+	"ssh":    {"SSH_AUTH_SOCK", "SSH_AGENT_PID"},
+	"docker": {"DOCKER_HOST", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY", "DOCKER_CONFIG", "DOCKER_CONTEXT"},
+	"aws":    {"AWS_*"},
+	"gcp":    {"GOOGLE_APPLICATION_CREDENTIALS", "GOOGLE_CLOUD_*", "CLOUDSDK_*", "GCLOUD_*"},
+	"azure":  {"AZURE_*", "ARM_CLIENT_*", "ARM_TENANT_ID", "ARM_SUBSCRIPTION_ID"},
+}
+
+// notCredentials match the patterns above but only configure the client.
+var notCredentials = []string{"AWS_CA_BUNDLE", "AWS_REGION", "AWS_DEFAULT_REGION", "*_CA_CERTS_FILE"}
+
+var (
+	pass_credentials stringList
+	block_env        stringList
+)
+
+func init() {
+	flag.Var(&pass_credentials, "pass-credentials", "Let the program see these credentials: ssh, docker, aws, gcp, azure or a variable name or glob (repeatable)")
+	flag.Var(&block_env, "block-env", "Also keep this variable, or glob, from the program (repeatable)")
+}
+
+// passing reports whether credentials of the given kind are passed on.
+func passing(kind string) bool {
+	return contains(pass_credentials, kind)
+}
+
+// blocked reports whether the variable name is kept from the program.
+func blocked(name string) bool {
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(block_env) {
+		return true
+	}
+	if matches(pass_credentials) || matches(notCredentials) {
+		return false
+	}
+	for kind, patterns := range credentials {
+		if !passing(kind) && matches(patterns) {
+			return true
+		}
+	}
+	return false
+}
+
+var reportBlocked sync.Once
+
+// withoutCredentials drops the blocked variables from env.
+func withoutCredentials(env []string) (kept []string) {
+	var dropped []string
+	for _, kv := range env {
+		name := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			name = kv[:i]
+		}
+		if blocked(name) {
+			dropped = append(dropped, name)
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	if len(dropped) > 0 {
+		reportBlocked.Do(func() {
+			sort.Strings(dropped)
+			log.Printf("not passing %s to the program (see --pass-credentials)", strings.Join(dropped, ", "))
+		})
+	}
+	return
+}
+
+// containerCredentials are the run arguments that forward the passed
+// credentials into a container, where the environment doesn't reach.
+func containerCredentials() (args []string) {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" && passing("ssh") {
+		args = append(args, "-v", sock+":/run/ssh-agent.sock", "-e", "SSH_AUTH_SOCK=/run/ssh-agent.sock")
+	}
+	if passing("docker") {
+		args = append(args, "-v", "/var/run/docker.sock:/var/run/docker.sock")
+	}
+	for _, kind := range []string{"aws", "gcp", "azure"} {
+		if !passing(kind) {
+			continue
+		}
+		for _, kv := range os.Environ() {
+			name := kv[:strings.Index(kv, "=")]
+			for _, p := range credentials[kind] {
+				if ok, _ := path.Match(p, name); ok {
+					// -e NAME takes the value from our environment.
+					args = append(args, "-e", name)
+					break
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,314 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// The daemon protocol is line based. A session sends
+//
+//	scan <import path>
+//	watch <dir>
+//
+// and receives
+//
+//	event <op> <file>
+//
+// for changes in the directories it asked for, until it hangs up. The daemon
+// also relays the decisions of chained instances (see chain.go): a session
+// that sends
+//
+//	lead
+//
+// goes on to send its decisions as change and rebuild lines, which every
+// session that sent
+//
+//	follow
+//
+// receives.
+
+var opNames = map[watch.Op]string{
+	watch.Create: "create",
+	watch.Write:  "write",
+	watch.Remove: "remove",
+	watch.Rename: "rename",
+	watch.Chmod:  "chmod",
+	watch.Save:   "save",
+}
+
+func opName(op watch.Op) string {
+	return opNames[op]
+}
+
+func parseOp(name string) watch.Op {
+	for op, n := range opNames {
+		if n == name {
+			return op
+		}
+	}
+	return watch.Write
+}
+
+func defaultDaemonAddr() string {
+	return filepath.Join(stateDir(), "daemon.sock")
+}
+
+// network guesses whether addr is a TCP host:port or a unix socket path.
+func network(addr string) string {
+	if strings.Contains(addr, ":") && !filepath.IsAbs(addr) {
+		return "tcp"
+	}
+	return "unix"
+}
+
+// a watchDaemon owns one set of file system watches on behalf of many
+// sessions, and caches their dependency scans.
+type watchDaemon struct {
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	refs    map[string]int
+	clients map[*daemonClient]bool
+	scans   map[string][]string
+	edits   int // bumped whenever scans is cleared
+
+	scanMu sync.Mutex // held while scanning, for graph
+	graph  depGraph
+}
+
+type daemonClient struct {
+	dirs    map[string]bool
+	out     chan string
+	follows bool
+}
+
+func serveDaemon(addr string) (err error) {
+	nw := network(addr)
+	if nw == "unix" {
+		os.MkdirAll(filepath.Dir(addr), 0755)
+		os.Remove(addr)
+	}
+	ln, err := net.Listen(nw, addr)
+	if err != nil {
+		return
+	}
+	defer ln.Close()
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	d := &watchDaemon{
+		watcher: w,
+		refs:    map[string]int{},
+		clients: map[*daemonClient]bool{},
+		scans:   map[string][]string{},
+		graph:   depGraph{},
+	}
+	go d.dispatch()
+	log.Printf("watcher daemon listening on %s", addr)
+	for {
+		var conn net.Conn
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		go d.serve(conn)
+	}
+}
+
+// dispatch hands each event to the sessions watching its directory.
+func (d *watchDaemon) dispatch() {
+	for {
+		select {
+		case we, ok := <-d.watcher.Event:
+			if !ok {
+				return
+			}
+			ev := translate(we)
+			line := fmt.Sprintf("event %s %s\n", opName(ev.Op), ev.Name)
+			d.mu.Lock()
+			if filepath.Ext(ev.Name) == ".go" {
+				// imports may have changed, scan again next time.
+				d.scans = map[string][]string{}
+				d.edits++
+			}
+			for c := range d.clients {
+				if !c.dirs[filepath.Dir(ev.Name)] && !c.dirs[ev.Name] {
+					continue
+				}
+				select {
+				case c.out <- line:
+				default:
+					log.Printf("a session is not keeping up, dropped %s", ev.Name)
+				}
+			}
+			d.mu.Unlock()
+		case err := <-d.watcher.Error:
+			log.Printf("watcher error: %s", err)
+		}
+	}
+}
+
+// scan resolves the dependency directories of importpath, reusing the
+// result of another session's scan when nothing has changed since.
+// The scan itself runs without d.mu, so that events keep flowing to the
+// other sessions meanwhile.
+func (d *watchDaemon) scan(importpath string) []string {
+	d.mu.Lock()
+	dirs, ok := d.scans[importpath]
+	edits := d.edits
+	d.mu.Unlock()
+	if ok {
+		return dirs
+	}
+	d.scanMu.Lock()
+	dirs = scanDirs(importpath, d.graph)
+	d.scanMu.Unlock()
+	d.mu.Lock()
+	// unless a .go file changed meanwhile, which the scan may have missed.
+	if d.edits == edits {
+		d.scans[importpath] = dirs
+	}
+	d.mu.Unlock()
+	return dirs
+}
+
+func (d *watchDaemon) add(c *daemonClient, dir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c.dirs[dir] {
+		return
+	}
+	c.dirs[dir] = true
+	if d.refs[dir] == 0 {
+		err := d.watcher.Watch(dir)
+		if err != nil {
+			log.Printf("error watching %s: %s", dir, err)
+		}
+	}
+	d.refs[dir]++
+}
+
+func (d *watchDaemon) remove(c *daemonClient) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.clients, c)
+	for dir := range c.dirs {
+		d.refs[dir]--
+		if d.refs[dir] == 0 {
+			delete(d.refs, dir)
+			d.watcher.RemoveWatch(dir)
+		}
+	}
+	close(c.out)
+}
+
+func (d *watchDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &daemonClient{dirs: map[string]bool{}, out: make(chan string, 256)}
+	d.mu.Lock()
+	d.clients[c] = true
+	d.mu.Unlock()
+	go func() {
+		for line := range c.out {
+			fmt.Fprint(conn, line)
+		}
+	}()
+	defer d.remove(c)
+
+	scanner := bufio.NewScanner(conn)
+	var decision strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.SplitN(line, " ", 2)
+		switch {
+		case line == "follow":
+			d.mu.Lock()
+			c.follows = true
+			d.mu.Unlock()
+		case line == "lead":
+			log.Printf("%s leads", conn.RemoteAddr())
+		case line == "rebuild":
+			decision.WriteString("rebuild\n")
+			d.relay(decision.String())
+			decision.Reset()
+		case len(fields) != 2:
+		case fields[0] == "change":
+			decision.WriteString(line + "\n")
+		case fields[0] == "scan":
+			for _, dir := range d.scan(fields[1]) {
+				d.add(c, dir)
+			}
+		case fields[0] == "watch":
+			d.add(c, fields[1])
+		}
+	}
+}
+
+// relay passes a leader's decision on to the sessions following.
+func (d *watchDaemon) relay(decision string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for c := range d.clients {
+		if !c.follows {
+			continue
+		}
+		select {
+		case c.out <- decision:
+		default:
+			log.Printf("a follower is not keeping up, dropped a rebuild")
+		}
+	}
+}
+
+// a daemonWatcher gets a session's events from the shared daemon.
+type daemonWatcher struct {
+	conn net.Conn
+}
+
+func dialDaemon(addr, buildpath string, extra []string, events chan<- watch.Event) (w *daemonWatcher, err error) {
+	conn, err := net.DialTimeout(network(addr), addr, 5*time.Second)
+	if err != nil {
+		return
+	}
+	if buildpath != "" {
+		_, err = fmt.Fprintf(conn, "scan %s\n", buildpath)
+	}
+	for _, dir := range extra {
+		if err == nil {
+			_, err = fmt.Fprintf(conn, "watch %s\n", dir)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			fields := strings.SplitN(scanner.Text(), " ", 3)
+			if len(fields) != 3 || fields[0] != "event" {
+				continue
+			}
+			events <- watch.Event{Name: fields[2], Op: parseOp(fields[1]), Time: time.Now()}
+		}
+	}()
+	return &daemonWatcher{conn: conn}, nil
+}
+
+func (w *daemonWatcher) Close() error {
+	return w.conn.Close()
+}
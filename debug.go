@@ -0,0 +1,57 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+var (
+	debug_build  = flag.Bool("debug", false, "Build without optimizations and run the program under a headless delve, which debuggers can attach to at --debug-listen after every restart")
+	debug_listen = flag.String("debug-listen", "127.0.0.1:2345", "With --debug, where delve listens for debuggers")
+)
+
+// debugGcflags are the -gcflags for --debug: no optimizations and no
+// inlining anywhere, along with --gcflags. The go command keeps only one
+// -gcflags per package, so --gcflags joins them for all packages, dropping
+// a pattern of its own.
+func debugGcflags() []string {
+	gcflags := strings.TrimSpace(*build_gcflags)
+	if i := strings.Index(gcflags, "="); i >= 0 && !strings.HasPrefix(gcflags, "-") && !strings.ContainsAny(gcflags[:i], " \t") {
+		gcflags = gcflags[i+1:]
+	}
+	return []string{"-gcflags", strings.TrimSpace("all=-N -l " + gcflags)}
+}
+
+// checkDebug makes sure --debug can work.
+func checkDebug() error {
+	if noBuild() || *container_image != "" || *emulator != "" || len(wrap_cmd) > 0 {
+		return errors.New("--debug builds the program and runs it under delve, it does not work with --prebuilt, --exec, --containerize, --emulator or --wrap")
+	}
+	if *standby_addr != "" || *handoff {
+		return errors.New("--debug keeps one delve on --debug-listen, it does not work with --standby or --handoff, which run two builds at once")
+	}
+	if _, err := exec.LookPath("dlv"); err != nil {
+		return errors.New("--debug needs delve: go install github.com/go-delve/delve/cmd/dlv@latest")
+	}
+	return nil
+}
+
+// debugCommand starts the program under delve, which lets it run right
+// away and keeps serving debuggers on the same address from one restart to
+// the next.
+func (s *session) debugCommand() *exec.Cmd {
+	log.Printf("debugger listening on %s", *debug_listen)
+	args := []string{"exec", "--headless", "--continue", "--accept-multiclient", "--api-version=2",
+		"--listen=" + *debug_listen, s.binPath}
+	if len(s.args) > 0 {
+		args = append(append(args, "--"), s.args...)
+	}
+	return command("dlv", args...)
+}
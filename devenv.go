@@ -0,0 +1,95 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var dev_env = flag.String("dev-env", "", "Run builds and the program inside the project environment: nix (flake) or direnv")
+
+// the files that define each kind of environment.
+var devEnvFiles = map[string][]string{
+	"nix":    {"flake.nix", "flake.lock"},
+	"direnv": {".envrc"},
+}
+
+var devEnv struct {
+	once sync.Once
+	root string
+}
+
+// devEnvRoot finds the directory defining the environment, looking upwards
+// from the working directory.
+func devEnvRoot() string {
+	devEnv.once.Do(func() {
+		if *dev_env == "" {
+			return
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			return
+		}
+		for {
+			for _, name := range devEnvFiles[*dev_env] {
+				if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					devEnv.root = dir
+					return
+				}
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				log.Printf("no %s environment found above the working directory", *dev_env)
+				return
+			}
+			dir = parent
+		}
+	})
+	return devEnv.root
+}
+
+// command is exec.Command, run inside the project environment if one was
+// asked for.
+func command(name string, args ...string) *exec.Cmd {
+	root := devEnvRoot()
+	switch {
+	case *dev_env == "" || root == "":
+		return exec.Command(name, args...)
+	case *dev_env == "nix":
+		return exec.Command("nix", append([]string{"develop", root, "-c", name}, args...)...)
+	case *dev_env == "direnv":
+		return exec.Command("direnv", append([]string{"exec", root, name}, args...)...)
+	}
+	log.Printf("unknown --dev-env %q", *dev_env)
+	return exec.Command(name, args...)
+}
+
+// devEnvFilter keeps changes to the files defining the environment.
+func devEnvFilter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		return isDevEnvFile(ev.Name)
+	})
+}
+
+func isDevEnvFile(name string) bool {
+	root := devEnvRoot()
+	if root == "" || filepath.Dir(name) != root {
+		return false
+	}
+	for _, n := range devEnvFiles[*dev_env] {
+		if filepath.Base(name) == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+)
+
+// a doctor collects the findings of the environment checks.
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (d *doctor) warn(format string, args ...interface{}) {
+	d.problems++
+	fmt.Printf("warn  "+format+"\n", args...)
+}
+
+// diagnose checks the environment rerun depends on and reports actionable
+// problems. buildpath may be empty.
+func diagnose(buildpath string) (problems int) {
+	d := &doctor{}
+	d.checkGo(buildpath)
+	d.checkBinDir()
+	d.checkInotify()
+	d.checkNotify()
+	d.checkPIDFiles()
+	d.checkClock()
+	return d.problems
+}
+
+func goEnv(name string) string {
+	out, err := exec.Command("go", "env", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (d *doctor) checkGo(buildpath string) {
+	version, err := checkToolchain(".")
+	if err != nil {
+		d.warn("%s", err)
+		return
+	}
+	d.ok("go command runs, building with %s", version)
+
+	gomod := goEnv("GOMOD")
+	mode := goEnv("GO111MODULE")
+	switch {
+	case gomod != "" && gomod != os.DevNull:
+		d.ok("module mode (%s)", gomod)
+	case mode == "off":
+		d.ok("GOPATH mode (GO111MODULE=off)")
+	default:
+		d.warn("no go.mod found but GO111MODULE=%q: set GO111MODULE=off or add a go.mod", mode)
+	}
+
+	if buildpath == "" {
+		return
+	}
+	pkg, err := build.Import(buildpath, "", 0)
+	if err != nil {
+		d.warn("cannot import %s: %s", buildpath, err)
+		return
+	}
+	if pkg.Name != "main" {
+		d.warn("%s is package %q, not a command", buildpath, pkg.Name)
+		return
+	}
+	d.ok("%s found in %s", buildpath, pkg.Dir)
+}
+
+func (d *doctor) checkBinDir() {
+	dir := os.TempDir()
+	if *output != "" {
+		dir = filepath.Dir(*output)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		var f *os.File
+		f, err = ioutil.TempFile(dir, ".rerun-doctor")
+		if err == nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		d.warn("binaries are built to %s, which is not writable: %s", dir, err)
+		return
+	}
+	d.ok("binaries are built to %s", dir)
+}
+
+func (d *doctor) checkInotify() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	data, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return
+	}
+	if n < 65536 {
+		d.warn("fs.inotify.max_user_watches is %d; large workspaces may run out (sysctl fs.inotify.max_user_watches=524288)", n)
+		return
+	}
+	d.ok("fs.inotify.max_user_watches is %d", n)
+}
+
+// checkNotify makes sure file system events are actually delivered.
+func (d *doctor) checkNotify() {
+	dir, err := ioutil.TempDir("", "rerun-doctor")
+	if err != nil {
+		d.warn("cannot create a temporary directory: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		d.warn("file system notifications are unavailable: %s", err)
+		return
+	}
+	defer watcher.Close()
+	err = watcher.Watch(dir)
+	if err != nil {
+		d.warn("cannot watch %s: %s", dir, err)
+		return
+	}
+	go func() {
+		for range watcher.Error {
+		}
+	}()
+	ioutil.WriteFile(filepath.Join(dir, "probe.go"), []byte("package probe\n"), 0644)
+	select {
+	case <-watcher.Event:
+		d.ok("file system notifications are delivered")
+	case <-time.After(2 * time.Second):
+		d.warn("no file system notification arrived within 2s")
+	}
+}
+
+// checkPIDFiles looks for the PID files of sessions that did not shut down.
+func (d *doctor) checkPIDFiles() {
+	names, _ := filepath.Glob(filepath.Join(stateDir(), "run", "*.pid"))
+	stale := 0
+	for _, name := range names {
+		pid, ok := readPID(name)
+		if ok && processAlive(pid) {
+			continue
+		}
+		stale++
+		d.warn("stale PID file %s from a session that did not shut down cleanly; remove it", name)
+	}
+	if stale == 0 {
+		d.ok("no stale PID files")
+	}
+}
+
+// checkClock compares the time the file system stamps on a new file with the
+// local clock; a large difference breaks anything that relies on mtimes.
+func (d *doctor) checkClock() {
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	f, err := ioutil.TempFile(wd, ".rerun-doctor")
+	if err != nil {
+		return
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return
+	}
+	skew := time.Since(fi.ModTime())
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > 2*time.Second {
+		d.warn("file modification times in %s are %s off the local clock", wd, skew.Round(time.Second))
+		return
+	}
+	d.ok("file system and local clocks agree")
+}
+
+func pidPath(buildpath string) string {
+	return filepath.Join(stateDir(), "run", projectKey(buildpath)+".pid")
+}
+
+func readPID(name string) (pid int, ok bool) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil
+}
+
+// writePID records this session in a PID file, warning when another live
+// session already holds it.
+func writePID(buildpath string) {
+	name := pidPath(buildpath)
+	if pid, ok := readPID(name); ok && pid != os.Getpid() && processAlive(pid) {
+		fmt.Fprintf(os.Stderr, "warning: another rerun session (pid %d) is running for %s\n", pid, buildpath)
+	}
+	os.MkdirAll(filepath.Dir(name), 0755)
+	ioutil.WriteFile(name, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePID removes this session's PID file if it still owns it.
+func removePID(buildpath string) {
+	name := pidPath(buildpath)
+	if pid, ok := readPID(name); ok && pid == os.Getpid() {
+		os.Remove(name)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// an editorMessage is the part of an LSP style notification rerun cares about.
+type editorMessage struct {
+	Method string `json:"method"`
+	Params struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+	} `json:"params"`
+}
+
+// readEditor reads save notifications sent by an editor plugin and turns
+// them into events. Messages are either framed LSP style, with a
+// Content-Length header, or given one JSON object per line:
+//
+//	{"method": "textDocument/didSave", "params": {"textDocument": {"uri": "file:///src/main.go"}}}
+//
+// It is a notification only protocol; nothing is written back.
+func readEditor(r io.Reader, events chan<- watch.Event) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		var body []byte
+		switch {
+		case strings.HasPrefix(line, "Content-Length:"):
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				continue
+			}
+			// skip the remaining headers up to the blank line.
+			for {
+				h, err := br.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.TrimSpace(h) == "" {
+					break
+				}
+			}
+			body = make([]byte, n)
+			_, err = io.ReadFull(br, body)
+			if err != nil {
+				return
+			}
+		case strings.HasPrefix(line, "{"):
+			body = []byte(line)
+		default:
+			continue
+		}
+
+		var msg editorMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			log.Printf("bad editor message: %s", err)
+			continue
+		}
+		if msg.Method != "textDocument/didSave" {
+			continue
+		}
+		name, ok := uriPath(msg.Params.TextDocument.URI)
+		if !ok {
+			continue
+		}
+		events <- watch.Event{Name: name, Op: watch.Save, Time: time.Now()}
+	}
+}
+
+// uriPath turns a file:// URI into a local path.
+func uriPath(uri string) (name string, ok bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return
+	}
+	name = u.Path
+	if runtime.GOOS == "windows" {
+		name = strings.TrimPrefix(name, "/")
+	}
+	return filepath.FromSlash(name), true
+}
@@ -0,0 +1,47 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var emulator = flag.String("emulator", "", "Load the program into a local serverless emulator after each build: lambda, or a command where {} stands for the binary")
+
+// the emulators rerun knows how to drive out of the box.
+var emulatorPresets = map[string]struct {
+	command string
+	note    string
+}{
+	"lambda": {
+		command: "aws-lambda-rie {}",
+		note:    "invoke with POST http://localhost:8080/2015-03-31/functions/function/invocations",
+	},
+}
+
+// emulatorCommand starts the emulator with the freshly built handler. The
+// emulator is restarted on every build, so it always serves the new code.
+func (s *session) emulatorCommand() *exec.Cmd {
+	tmpl := *emulator
+	if preset, ok := emulatorPresets[tmpl]; ok {
+		tmpl = preset.command
+		log.Printf("%s emulator: %s", *emulator, preset.note)
+	}
+	var args []string
+	for _, f := range strings.Fields(tmpl) {
+		args = append(args, strings.Replace(f, "{}", s.binPath, -1))
+	}
+	args = append(args, s.args...)
+	cmd := command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"AWS_LAMBDA_FUNCTION_NAME="+s.binName,
+		"_HANDLER="+s.binName,
+	)
+	return cmd
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var env_files stringList
+
+func init() {
+	flag.Var(&env_files, "env-file", "Add the KEY=value lines of this file to the program's environment, read again on every restart; editing it restarts the program (repeatable)")
+}
+
+// readEnvFile reads a dotenv style file: KEY=value lines, optionally after
+// "export ", with the value optionally quoted, and # comments, which may
+// follow an unquoted value.
+func readEnvFile(name string) (env []string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", name, n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// envFileEnv is what the --env-file files add to the program's environment.
+func envFileEnv() (env []string) {
+	for _, name := range env_files {
+		vars, err := readEnvFile(name)
+		if err != nil {
+			log.Printf("not using %s: %s", name, err)
+			continue
+		}
+		env = append(env, vars...)
+	}
+	return
+}
+
+// isEnvFile reports whether name is one of the --env-file files.
+func isEnvFile(name string) bool {
+	for _, f := range env_files {
+		if abs, err := filepath.Abs(f); err == nil && abs == name {
+			return true
+		}
+	}
+	return false
+}
+
+// volatileEnv changes on every restart, so there is no point reporting it.
+var volatileEnv = map[string]bool{"RERUN_BUILD_ID": true, "PORT": true}
+
+var secretName = regexp.MustCompile(`(?i)secret|token|passw|key|credential|auth|private|cookie|session`)
+
+// envMap turns KEY=value pairs into a map; later ones win, like they do for
+// the program.
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i >= 0 {
+			m[kv[:i]] = kv[i+1:]
+		}
+	}
+	return m
+}
+
+// envDiff describes how the environment changed from old to cur, one line
+// per variable. The values of variables named like secrets are left out.
+func envDiff(old, cur []string) (lines []string) {
+	before, after := envMap(old), envMap(cur)
+	shown := func(name, value string) string {
+		if secretName.MatchString(name) || blocked(name) {
+			return "(hidden)"
+		}
+		return fmt.Sprintf("%q", value)
+	}
+	for name, value := range after {
+		if volatileEnv[name] {
+			continue
+		}
+		was, ok := before[name]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("+ %s=%s", name, shown(name, value)))
+		case was != value:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", name, shown(name, was), shown(name, value)))
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok && !volatileEnv[name] {
+			lines = append(lines, fmt.Sprintf("- %s", name))
+		}
+	}
+	// by name, whatever the change.
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	return
+}
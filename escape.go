@@ -0,0 +1,128 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the compiler's -m output that is worth comparing between builds.
+var escapeKinds = []string{
+	"escapes to heap",
+	"moved to heap",
+	"leaking param",
+	"can inline",
+	"inlining call to",
+}
+
+// escapeReports holds the last escape analysis and inlining decisions seen
+// for each package, keyed by import path.
+type escapeReports map[string][]string
+
+// packageOf finds the import path of the package a changed file belongs to:
+// one the last scan found in its directory or, failing that, what go list
+// says. go/build can't tell in module mode.
+func (s *session) packageOf(file string) (importpath string, ok bool) {
+	dir := filepath.Dir(file)
+	for importpath, pkg := range s.graph {
+		if pkg.Dir == dir {
+			return importpath, true
+		}
+	}
+	cmd := command("go", append(append([]string{"list", "-f", "{{.ImportPath}}"}, tagArgs()...), dir)...)
+	cmd.Dir = dir
+	cmd.Env = installEnv()
+	out, err := cmd.Output()
+	importpath = strings.TrimSpace(string(out))
+	if err != nil || importpath == "" || importpath == "." || strings.HasPrefix(importpath, "_") {
+		return "", false
+	}
+	return importpath, true
+}
+
+// escapeDecisions compiles importpath with -gcflags=-m and returns its
+// filtered diagnostics. Line and column numbers are dropped so that editing
+// one function does not make every later decision look new.
+func escapeDecisions(importpath string) (decisions []string, err error) {
+	args := append([]string{"build", "-gcflags=-m", "-o", os.DevNull}, tagArgs()...)
+	cmd := command("go", append(args, importpath)...)
+	cmd.Env = installEnv()
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		msg := strings.TrimSpace(parts[3])
+		for _, kind := range escapeKinds {
+			if strings.Contains(msg, kind) {
+				decisions = append(decisions, fmt.Sprintf("%s: %s", filepath.Base(parts[0]), msg))
+				break
+			}
+		}
+	}
+	sort.Strings(decisions)
+	return
+}
+
+// update recomputes the decisions for each package and prints what was added
+// and removed compared to the previous build.
+func (reports escapeReports) update(pkgs []string) {
+	for _, importpath := range pkgs {
+		decisions, err := escapeDecisions(importpath)
+		if err != nil {
+			continue
+		}
+		last, seen := reports[importpath]
+		reports[importpath] = decisions
+		if !seen {
+			log.Printf("escape: %s has %d decisions", importpath, len(decisions))
+			continue
+		}
+		added, removed := diffLines(last, decisions)
+		if len(added) == 0 && len(removed) == 0 {
+			log.Printf("escape: %s unchanged", importpath)
+			continue
+		}
+		log.Printf("escape: %s", importpath)
+		for _, line := range removed {
+			fmt.Printf("- %s\n", line)
+		}
+		for _, line := range added {
+			fmt.Printf("+ %s\n", line)
+		}
+	}
+}
+
+// diffLines compares two sorted lists as multisets.
+func diffLines(a, b []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			removed = append(removed, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			added = append(added, b[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var exec_cmd = flag.String("exec", "", "Run this shell command instead of a go program, and rerun it whenever a file below the current directory changes")
+
+// noBuild reports whether rerun supervises something it doesn't build.
+func noBuild() bool {
+	return *prebuilt || *exec_cmd != ""
+}
+
+// skipDir reports whether a directory is not worth watching for --exec.
+func skipDir(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") && base != "." ||
+		base == "node_modules" || base == "vendor"
+}
+
+// execDirs are the directories watched for --exec: the current directory
+// and everything below it.
+func execDirs() (dirs []string) {
+	if *exec_cmd == "" {
+		return
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	filepath.Walk(wd, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if name != wd && skipDir(name) {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, name)
+		return nil
+	})
+	return
+}
+
+// execFilter keeps, for --exec, the changes to any file that isn't hidden or
+// an editor's backup.
+func execFilter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if *exec_cmd == "" {
+			return false
+		}
+		base := filepath.Base(ev.Name)
+		return !strings.HasPrefix(base, ".") && !strings.HasSuffix(base, "~") &&
+			!strings.HasSuffix(base, ".swp")
+	})
+}
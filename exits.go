@@ -0,0 +1,168 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how many starts and stops are kept for the API.
+const keepProcEvents = 200
+
+// A procEvent is the persisted record of the program starting or stopping.
+type procEvent struct {
+	Event   string        `json:"event"` // "start" or "stop"
+	Time    time.Time     `json:"time"`
+	PID     int           `json:"pid"`
+	Build   int           `json:"build,omitempty"`
+	Cycle   int           `json:"cycle,omitempty"`
+	Reason  string        `json:"reason"`
+	Trigger []string      `json:"trigger,omitempty"`
+	Exit    string        `json:"exit,omitempty"`
+	Code    *int          `json:"code,omitempty"` // unless a signal ended it
+	Signal  string        `json:"signal,omitempty"`
+	Uptime  time.Duration `json:"uptime,omitempty"`
+}
+
+// procLog keeps the most recent starts and stops of the program, and
+// appends every one to the project's process history.
+type procLog struct {
+	mu     sync.Mutex
+	events []procEvent
+}
+
+func processHistoryPath(buildpath string) string {
+	return filepath.Join(stateDir(), "processes", projectKey(buildpath)+".json")
+}
+
+func (l *procLog) add(buildpath string, ev procEvent) {
+	l.mu.Lock()
+	l.events = append(l.events, ev)
+	if len(l.events) > keepProcEvents {
+		l.events = l.events[len(l.events)-keepProcEvents:]
+	}
+	l.mu.Unlock()
+	if err := appendProcEvent(buildpath, ev); err != nil {
+		log.Printf("error writing process history: %s", err)
+	}
+}
+
+func (l *procLog) recent() []procEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]procEvent{}, l.events...)
+}
+
+func appendProcEvent(buildpath string, ev procEvent) (err error) {
+	name := processHistoryPath(buildpath)
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(ev)
+	return
+}
+
+// procStarted records that c started for l, for reason.
+func (s *session) procStarted(c *child, l launch, reason string) {
+	s.procs.add(s.buildpath, procEvent{
+		Event:   "start",
+		Time:    c.started,
+		PID:     c.proc.Pid,
+		Build:   l.build,
+		Cycle:   l.cycle,
+		Reason:  reason,
+		Trigger: l.trigger,
+	})
+}
+
+// procStopped records how c, which ran build, exited and why.
+func (s *session) procStopped(c *child, build int, reason string) {
+	ev := procEvent{
+		Event:  "stop",
+		Time:   time.Now(),
+		PID:    c.proc.Pid,
+		Build:  build,
+		Reason: reason,
+		Exit:   exitDescription(c.err),
+		Uptime: time.Since(c.started).Round(time.Millisecond),
+	}
+	if sig := exitSignal(c.err); sig != "" {
+		ev.Signal = sig
+	} else {
+		code := childExitCode(c.err)
+		ev.Code = &code
+	}
+	s.procs.add(s.buildpath, ev)
+}
+
+// exitSignal names the signal that ended a program, as Wait says, or is ""
+// if it exited by itself.
+func exitSignal(err error) string {
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return ""
+	}
+	if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return ""
+}
+
+func readProcessHistory(buildpath string) (evs []procEvent, err error) {
+	f, err := os.Open(processHistoryPath(buildpath))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev procEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) != nil {
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	err = scanner.Err()
+	return
+}
+
+// showProcessHistory prints the last n starts and stops recorded for
+// buildpath.
+func showProcessHistory(buildpath string, n int) (err error) {
+	evs, err := readProcessHistory(buildpath)
+	if err != nil {
+		return
+	}
+	if n > 0 && len(evs) > n {
+		evs = evs[len(evs)-n:]
+	}
+	for _, ev := range evs {
+		fmt.Printf("%s  %-5s pid %-7d build %-4d %s", ev.Time.Format("2006-01-02 15:04:05"), ev.Event, ev.PID, ev.Build, ev.Reason)
+		if ev.Event == "stop" {
+			fmt.Printf(", %s after %s", ev.Exit, ev.Uptime)
+		}
+		fmt.Println()
+		if len(ev.Trigger) > 0 {
+			fmt.Printf("    %s\n", strings.Join(ev.Trigger, " "))
+		}
+	}
+	return
+}
@@ -0,0 +1,79 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var watch_ext = flag.String("ext", "go", "Comma separated extensions of the files that trigger a rebuild, e.g. go,tmpl,html,css,sql")
+
+// watchedExts are the extensions given with --ext, with their dots.
+func watchedExts() (exts []string) {
+	for _, ext := range strings.Split(*watch_ext, ",") {
+		ext = strings.TrimSpace(ext)
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return
+}
+
+// extFilter keeps changes to files with one of the --ext extensions.
+func extFilter() watch.EventFilter {
+	return watch.Ext(watchedExts()...)
+}
+
+// isDataFile reports whether name is one of the non-Go files that --ext
+// asks for. The program may read them at startup, so a change restarts it
+// even when the binary comes out the same.
+func isDataFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext != ".go" && contains(watchedExts(), ext)
+}
+
+// extDirs are the directories below the main package holding files with a
+// non-Go --ext extension, such as templates/ or migrations/.
+func (s *session) extDirs() (dirs []string) {
+	if noBuild() || s.dir == "" {
+		return
+	}
+	var exts []string
+	for _, ext := range watchedExts() {
+		if ext != ".go" {
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) == 0 {
+		return
+	}
+	seen := map[string]bool{}
+	filepath.Walk(s.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi.IsDir() {
+			if name != s.dir && skipDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if dir := filepath.Dir(name); contains(exts, filepath.Ext(name)) && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,47 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// serveFIFO reads trigger lines from the named pipe at name for as long as
+// rerun runs. Each writer may send any number of lines: a file path is
+// treated like a save of that file, and a bare "rebuild" forces a cycle.
+func serveFIFO(name string, events chan<- watch.Event) {
+	for {
+		r, err := openFIFO(name)
+		if err != nil {
+			log.Printf("error opening trigger pipe %s: %s", name, err)
+			return
+		}
+		readTriggers(r, events)
+		r.Close()
+	}
+}
+
+func readTriggers(r io.Reader, events chan<- watch.Event) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line != "rebuild" {
+			if abs, err := filepath.Abs(line); err == nil {
+				line = abs
+			}
+		}
+		events <- watch.Event{Name: line, Op: watch.Save, Time: time.Now()}
+	}
+}
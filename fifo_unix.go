@@ -0,0 +1,30 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// openFIFO creates the FIFO if needed and blocks until a writer opens it.
+// Anything else at name is refused: a regular file would be read over and
+// over without ever blocking.
+func openFIFO(name string) (r io.ReadCloser, err error) {
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		err = syscall.Mkfifo(name, 0600)
+	} else if err == nil && fi.Mode()&os.ModeNamedPipe == 0 {
+		err = fmt.Errorf("%s exists and is not a named pipe", name)
+	}
+	if err != nil {
+		return
+	}
+	return os.Open(name)
+}
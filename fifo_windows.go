@@ -0,0 +1,48 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+)
+
+const (
+	pipe_access_inbound  = 0x1
+	pipe_unlimited       = 255
+	error_pipe_connected = 535
+)
+
+// openFIFO creates an instance of the named pipe (under \\.\pipe\ unless a
+// full pipe name is given) and blocks until a writer connects to it.
+func openFIFO(name string) (r io.ReadCloser, err error) {
+	if !strings.HasPrefix(name, `\\.\pipe\`) {
+		name = `\\.\pipe\` + name
+	}
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return
+	}
+	h, _, e := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(p)), pipe_access_inbound, 0,
+		pipe_unlimited, 4096, 4096, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, os.NewSyscallError("CreateNamedPipe", e)
+	}
+	ok, _, e := procConnectNamedPipe.Call(h, 0)
+	if ok == 0 && e != syscall.Errno(error_pipe_connected) {
+		syscall.CloseHandle(syscall.Handle(h))
+		return nil, os.NewSyscallError("ConnectNamedPipe", e)
+	}
+	return os.NewFile(h, name), nil
+}
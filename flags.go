@@ -0,0 +1,131 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// windowList is a repeatable "pattern=duration" flag.
+type windowList []watch.Window
+
+func (l *windowList) String() string {
+	var parts []string
+	for _, w := range *l {
+		parts = append(parts, fmt.Sprintf("%s=%s", w.Pattern, w.Quiet))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *windowList) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return fmt.Errorf("expected pattern=duration, got %q", value)
+	}
+	quiet, err := time.ParseDuration(value[i+1:])
+	if err != nil {
+		return err
+	}
+	*l = append(*l, watch.Window{Pattern: value[:i], Quiet: quiet})
+	return nil
+}
+
+// signalFlag names the signal that asks the program to exit, as INT, TERM,
+// SIGTERM, ...
+type signalFlag struct {
+	name string
+	sig  os.Signal
+}
+
+func (f *signalFlag) String() string {
+	return f.name
+}
+
+func (f *signalFlag) Set(value string) error {
+	name := strings.TrimPrefix(strings.ToUpper(value), "SIG")
+	sig, ok := signalNames[name]
+	if !ok {
+		return fmt.Errorf("unknown signal %q", value)
+	}
+	f.name, f.sig = name, sig
+	return nil
+}
+
+// regexpFlag is a regular expression, or nil until set.
+type regexpFlag struct {
+	re *regexp.Regexp
+}
+
+func (f *regexpFlag) String() string {
+	if f.re == nil {
+		return ""
+	}
+	return f.re.String()
+}
+
+func (f *regexpFlag) Set(value string) (err error) {
+	f.re, err = regexp.Compile(value)
+	return
+}
+
+// argsFlag is a list of command line arguments given as one string, split at
+// spaces except inside single or double quotes: -run 'TestA|TestB' -short.
+type argsFlag []string
+
+func (f *argsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *argsFlag) Set(value string) error {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	inArg := false
+	for _, c := range value {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated %c quote in %q", quote, value)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	*f = args
+	return nil
+}
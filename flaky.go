@@ -0,0 +1,146 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var retry_flaky = flag.Int("retry-flaky", 0, "With --test, rerun failed tests up to this many times; tests that then pass are reported as suspected flakes")
+
+var resultLine = regexp.MustCompile(`^\s*--- (PASS|FAIL): (\S+)`)
+
+// testResults picks the outcome of every test out of go test -v output.
+func testResults(output string) (passed map[string]bool) {
+	passed = map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if m := resultLine.FindStringSubmatch(line); m != nil {
+			passed[m[2]] = m[1] == "PASS"
+		}
+	}
+	return
+}
+
+// flakes keeps the last outcome of every test, along with the code it ran
+// against, to spot tests that change their mind when nothing they depend on
+// has changed.
+type flakes struct {
+	sync.Mutex
+	last    map[string]testOutcome
+	suspect map[string]int
+}
+
+type testOutcome struct {
+	passed bool
+	code   string
+}
+
+func newFlakes() *flakes {
+	return &flakes{last: map[string]testOutcome{}, suspect: map[string]int{}}
+}
+
+// record notes the outcomes of one test run against code, and reports the
+// tests whose outcome flipped since the last run against the same code.
+func (f *flakes) record(code string, passed map[string]bool) (flipped []string) {
+	f.Lock()
+	defer f.Unlock()
+	for name, ok := range passed {
+		if last, seen := f.last[name]; seen && last.code == code && last.passed != ok {
+			f.suspect[name]++
+			flipped = append(flipped, name)
+		}
+		f.last[name] = testOutcome{passed: ok, code: code}
+	}
+	sort.Strings(flipped)
+	return
+}
+
+// flaked marks tests that failed and then passed on a retry.
+func (f *flakes) flaked(names []string) {
+	f.Lock()
+	defer f.Unlock()
+	for _, name := range names {
+		f.suspect[name]++
+	}
+}
+
+// list returns the suspected flakes, the most often caught first.
+func (f *flakes) list() (names []string) {
+	f.Lock()
+	defer f.Unlock()
+	for name := range f.suspect {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := f.suspect[names[i]], f.suspect[names[j]]
+		return a > b || a == b && names[i] < names[j]
+	})
+	return
+}
+
+// testCode fingerprints what the tests run against: the program's binary and
+// the package's test files.
+func (s *session) testCode(binary string) string {
+	h := sha256.New()
+	h.Write([]byte(binary))
+	names, _ := filepath.Glob(filepath.Join(s.dir, "*_test.go"))
+	for _, name := range names {
+		sum, _ := hashFile(name)
+		h.Write([]byte(name + sum))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// retryPattern is a -run pattern matching the top level tests of failed.
+func retryPattern(failed []string) string {
+	seen := map[string]bool{}
+	var tops []string
+	for _, name := range failed {
+		top := strings.SplitN(name, "/", 2)[0]
+		if !seen[top] {
+			seen[top] = true
+			tops = append(tops, regexp.QuoteMeta(top))
+		}
+	}
+	return "^(" + strings.Join(tops, "|") + ")$"
+}
+
+// retryTests reruns the failed tests up to --retry-flaky times. passed is
+// true once they all pass, in which case they are taken to be flakes.
+func (s *session) retryTests(ctx context.Context, failed []string) (passed bool, output string) {
+	if len(failed) == 0 {
+		// a build failure or a panic outside any test; retrying won't help.
+		return
+	}
+	for i := 1; i <= *retry_flaky; i++ {
+		log.Printf("retrying %s (%d/%d)", strings.Join(failed, ", "), i, *retry_flaky)
+		passed, output, _ = test(ctx, s.buildpath, s.cycle, retryPattern(failed))
+		if ctx.Err() != nil {
+			return
+		}
+		if passed {
+			log.Printf("suspected flaky: %s", strings.Join(failed, ", "))
+			s.flakes.flaked(failed)
+			return
+		}
+	}
+	return
+}
+
+// reportFlakes lists the suspected flakes of the session.
+func (s *session) reportFlakes() {
+	if names := s.flakes.list(); len(names) > 0 {
+		log.Printf("suspected flaky tests this session: %s", strings.Join(names, ", "))
+	}
+}
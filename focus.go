@@ -0,0 +1,132 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var focus_test = flag.String("focus", "", "With --test, only run the tests matching this regex, as go test -run")
+
+// keptTests is how many recently edited tests are offered.
+const keptTests = 20
+
+// testFocus narrows go test down to the tests being debugged.
+type testFocus struct {
+	mu     sync.Mutex
+	run    string
+	edited []string
+}
+
+var focus = &testFocus{}
+
+func (f *testFocus) get() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.run == "" {
+		return *focus_test
+	}
+	return f.run
+}
+
+// set focuses on the tests matching run; "" goes back to --focus.
+func (f *testFocus) set(run string) (err error) {
+	if _, err = regexp.Compile(run); err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.run = run
+	f.mu.Unlock()
+	if run == "" {
+		log.Println("running all tests again")
+	} else {
+		log.Printf("only running tests matching %s", run)
+	}
+	return
+}
+
+// saw remembers the tests declared in the changed test files, most
+// recent first.
+func (f *testFocus) saw(changed []string) {
+	var names []string
+	for _, name := range changed {
+		if !strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+		if err != nil {
+			continue
+		}
+		for _, obj := range file.Scope.Objects {
+			if obj.Kind == ast.Fun && strings.HasPrefix(obj.Name, "Test") {
+				names = append(names, obj.Name)
+			}
+		}
+	}
+	if names == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, old := range f.edited {
+		if !contains(names, old) {
+			names = append(names, old)
+		}
+	}
+	if len(names) > keptTests {
+		names = names[:keptTests]
+	}
+	f.edited = names
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// testChoices is what the control API offers to focus on.
+type testChoices struct {
+	Focus  string
+	Failed []string
+	Edited []string
+}
+
+// serveFocus handles the tests part of the control API:
+//
+//	GET /tests	the focus, and the recently failed and edited tests
+//	POST /tests/focus?run=regex	only run matching tests; no regex runs all again
+func (s *session) serveFocus(mux *http.ServeMux) {
+	mux.HandleFunc("/tests", func(w http.ResponseWriter, r *http.Request) {
+		focus.mu.Lock()
+		edited := append([]string(nil), focus.edited...)
+		focus.mu.Unlock()
+		writeJSON(w, testChoices{Focus: focus.get(), Failed: s.loopStatus().Failing, Edited: edited})
+	})
+	mux.HandleFunc("/tests/focus", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := focus.set(r.FormValue("run")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// run the focused tests right away.
+		go s.restartRequested()
+		writeJSON(w, focus.get())
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var (
+	include_globs stringList
+	exclude_globs stringList
+)
+
+func init() {
+	flag.Var(&include_globs, "include", "Only let changes to files matching this glob trigger a cycle, e.g. \"**/*.go\" (repeatable)")
+	flag.Var(&exclude_globs, "exclude", "Never let changes to files matching this glob trigger a cycle, e.g. \"**/testdata/**\" (repeatable)")
+}
+
+// globName is the name --include and --exclude patterns are matched against:
+// the path relative to the current directory when the file is below it, the
+// absolute path otherwise.
+func globName(name string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, name); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(name)
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if watch.Glob(g, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// globFilter drops the changes --exclude rules out and, if --include is given,
+// the ones it doesn't let in. Explicit saves and the binary itself are kept.
+func (s *session) globFilter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if ev.Op&watch.Save != 0 || ev.Name == s.binPath {
+			return true
+		}
+		name := globName(ev.Name)
+		if matchesAny(exclude_globs, name) {
+			return false
+		}
+		return len(include_globs) == 0 || matchesAny(include_globs, name)
+	})
+}
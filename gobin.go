@@ -0,0 +1,119 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var (
+	output     = flag.String("output", "", "Build the program to this path instead of a binary of its own in the temporary directory")
+	no_install = flag.Bool("no-install", false, "Like go run: build the program to a throwaway binary that is removed when rerun exits, and never download modules")
+)
+
+// installRetries is how often the build is retried when the binary is busy,
+// starting installBackoff apart and doubling.
+const (
+	installRetries = 4
+	installBackoff = 100 * time.Millisecond
+)
+
+// binaryPath is where the program built from the package in dir goes:
+// --output, or a file in the temporary directory named after the program and
+// dir, so that sessions for different packages don't collide and a session
+// leaves GOBIN alone.
+func binaryPath(name, dir string) (path string, err error) {
+	if *output != "" {
+		return filepath.Abs(*output)
+	}
+	sum := sha256.Sum256([]byte(dir))
+	name = fmt.Sprintf("rerun-%s-%x", name, sum[:6])
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(os.TempDir(), name), nil
+}
+
+// throwawayPath is where the program goes with --no-install: a directory
+// of this session's own, removed with the other temporary files.
+func throwawayPath(name string) (path string, err error) {
+	if *output != "" {
+		return "", errors.New("--no-install builds a throwaway binary, it does not work with --output")
+	}
+	dir, err := ioutil.TempDir("", "rerun-run-")
+	if err != nil {
+		return
+	}
+	created.add(dir)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// busyErrors are how the go command reports that it couldn't replace a
+// binary because someone is running or writing it.
+var busyErrors = []string{
+	"text file busy",
+	"being used by another process",
+	"Access is denied",
+}
+
+// busy reports whether output says the binary was busy.
+func busy(output string) bool {
+	for _, e := range busyErrors {
+		if strings.Contains(output, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// installPrivate builds the program into a binary of its own, named after
+// rerun's PID and the cycle, for when another rerun session or an IDE keeps
+// the shared binary busy. The session's binary moves there for good.
+func (s *session) installPrivate() (installed bool, errorOutput string) {
+	dir := filepath.Join(os.TempDir(), "rerun-bin")
+	os.MkdirAll(dir, 0755)
+	name := fmt.Sprintf("%s-%d-%d", s.binName, os.Getpid(), s.cycle)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(dir, name)
+
+	args := []string{"build", "-o", path}
+	if *race_detector {
+		args = append(args, "-race")
+	}
+	args = append(args, traceArgs()...)
+	args = append(args, buildFlags(s.buildID)...)
+	args = append(args, s.buildpath)
+	cmd := command("go", args...)
+	cmd.Env = installEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		errorOutput = string(out)
+		if errorOutput != s.errorOutput {
+			fmt.Print(errorOutput)
+		}
+		return
+	}
+	created.add(path)
+	if s.private {
+		// the old one may still be running; removing it is best effort.
+		os.Remove(s.binPath)
+	}
+	s.binPath, s.private = path, true
+	return true, ""
+}
@@ -0,0 +1,113 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"io"
+)
+
+// listedPackage is the part of go list -json output rerun needs.
+type listedPackage struct {
+	ImportPath  string
+	Name        string
+	Dir         string
+	Standard    bool
+	CgoFiles    []string
+	CgoCFLAGS   []string
+	CgoCPPFLAGS []string
+	CgoCXXFLAGS []string
+	Imports     []string
+	Module      *listedModule
+	Error       *listedError
+}
+
+type listedError struct {
+	Err string
+}
+
+type listedModule struct {
+	Path    string
+	Version string
+	Dir     string
+	Main    bool
+	Replace *listedModule
+}
+
+// editable reports whether the package's source can change under us: it is
+// in a main module (every module of a go.work workspace is one), in a module
+// replaced by a local directory, or not in a module at all (GOPATH mode).
+// Packages in the module cache, versioned replacements included, are left
+// alone.
+func (p *listedPackage) editable() bool {
+	m := p.Module
+	if m == nil || m.Main {
+		return true
+	}
+	return m.Replace != nil && m.Replace.Version == "" && m.Replace.Dir != "" && m.Replace.Dir == m.Dir
+}
+
+// listDirs is scanDirs done by go list -deps, which resolves imports the way
+// the go command will build them, modules and all. ok is false when go list
+// could not be run or a package could not be loaded, in which case the
+// caller falls back to go/build. go list -e succeeds regardless, so each
+// package has to be checked.
+func listDirs(importpath string, graph depGraph) (dirs []string, ok bool) {
+	args := append([]string{"list", "-e", "-deps", "-json"}, tagArgs()...)
+	cmd := command("go", append(args, importpath)...)
+	cmd.Env = installEnv()
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	var pkgs []*listedPackage
+	dec := json.NewDecoder(out)
+	for {
+		p := &listedPackage{}
+		if err = dec.Decode(p); err != nil {
+			break
+		}
+		pkgs = append(pkgs, p)
+	}
+	if werr := cmd.Wait(); err != io.EOF || werr != nil && len(pkgs) == 0 {
+		return nil, false
+	}
+	for _, p := range pkgs {
+		if p.Error != nil || p.Dir == "" {
+			// say, a file in the middle of a rename; go/build keeps
+			// watching what the package was.
+			return nil, false
+		}
+	}
+	for _, p := range pkgs {
+		if p.Standard || !p.editable() {
+			continue
+		}
+		pkg := &build.Package{
+			ImportPath:  p.ImportPath,
+			Name:        p.Name,
+			Dir:         p.Dir,
+			CgoFiles:    p.CgoFiles,
+			CgoCFLAGS:   p.CgoCFLAGS,
+			CgoCPPFLAGS: p.CgoCPPFLAGS,
+			CgoCXXFLAGS: p.CgoCXXFLAGS,
+			Imports:     p.Imports,
+		}
+		graph[p.ImportPath] = pkg
+		dirs = append(dirs, pkg.Dir)
+		if len(pkg.CgoFiles) > 0 {
+			addCgoDir(pkg.Dir)
+			for _, inc := range cgoIncludeDirs(pkg) {
+				addCgoDir(inc)
+				dirs = append(dirs, inc)
+			}
+		}
+	}
+	return dirs, true
+}
@@ -0,0 +1,44 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// ownGroup makes cmd the leader of a new process group, which everything it
+// starts joins, so that stopping it takes its subprocesses along.
+func ownGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// joinGroup is a no-op: the group exists as soon as the process does.
+func joinGroup(proc *os.Process) {}
+
+// signalGroup sends sig to proc's process group.
+func signalGroup(proc *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return proc.Signal(sig)
+	}
+	return syscall.Kill(-proc.Pid, s)
+}
+
+// killGroup kills proc's process group.
+func killGroup(proc *os.Process) {
+	if syscall.Kill(-proc.Pid, syscall.SIGKILL) != nil {
+		proc.Kill()
+	}
+}
+
+// leaveGroup forgets proc's group once it has exited.
+func leaveGroup(proc *os.Process) {}
@@ -0,0 +1,101 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var (
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+)
+
+const (
+	process_set_quota = 0x0100
+	process_terminate = 0x0001
+	ctrl_break_event  = 1
+)
+
+// jobs holds the job object of every started process, by pid.
+var jobs = struct {
+	sync.Mutex
+	handles map[int]syscall.Handle
+}{handles: map[int]syscall.Handle{}}
+
+// ownGroup starts cmd in a console process group of its own, which can be
+// interrupted without interrupting rerun. The job object that takes its
+// subprocesses along comes once it runs.
+func ownGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// joinGroup puts proc in a job object of its own, which the processes it
+// starts inherit, so that stopping it takes its subprocesses along.
+func joinGroup(proc *os.Process) {
+	job, _, _ := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return
+	}
+	h, err := syscall.OpenProcess(process_set_quota|process_terminate, false, uint32(proc.Pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	defer syscall.CloseHandle(h)
+	if ok, _, _ := procAssignProcessToJobObject.Call(job, uintptr(h)); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	jobs.Lock()
+	jobs.handles[proc.Pid] = syscall.Handle(job)
+	jobs.Unlock()
+}
+
+// signalGroup interrupts proc's console process group with a Ctrl-Break,
+// which go programs see as os.Interrupt. Without a console to send it
+// through, taskkill asks the program's windows to close instead.
+func signalGroup(proc *os.Process, sig os.Signal) error {
+	if sig != os.Interrupt {
+		return proc.Signal(sig)
+	}
+	if ok, _, _ := procGenerateConsoleCtrlEvent.Call(ctrl_break_event, uintptr(proc.Pid)); ok != 0 {
+		return nil
+	}
+	return exec.Command("taskkill", "/T", "/PID", strconv.Itoa(proc.Pid)).Run()
+}
+
+// killGroup terminates proc's job, or proc alone if it has none.
+func killGroup(proc *os.Process) {
+	jobs.Lock()
+	job, ok := jobs.handles[proc.Pid]
+	jobs.Unlock()
+	if !ok {
+		proc.Kill()
+		return
+	}
+	if r, _, _ := procTerminateJobObject.Call(uintptr(job), 1); r == 0 {
+		proc.Kill()
+	}
+}
+
+// leaveGroup closes proc's job once it has exited.
+func leaveGroup(proc *os.Process) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if job, ok := jobs.handles[proc.Pid]; ok {
+		syscall.CloseHandle(job)
+		delete(jobs.handles, proc.Pid)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A cycleRecord is the persisted outcome of one build cycle.
+type cycleRecord struct {
+	Cycle    int           `json:"cycle"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Trigger  []string      `json:"trigger"`
+	Result   string        `json:"result"`
+	Error    string        `json:"error,omitempty"`
+	Binary   string        `json:"binary,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+	Go       string        `json:"go,omitempty"`
+	Build    int           `json:"build,omitempty"`
+
+	output string // all of what failed, for the --proxy and --livereload overlay
+}
+
+// projectKey turns an import path into something usable as a file name.
+func projectKey(buildpath string) string {
+	// import paths only need their slashes replaced; --exec commands can
+	// hold anything.
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, buildpath)
+}
+
+// stateDir is where rerun keeps what it remembers between sessions.
+func stateDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "rerun")
+}
+
+func historyPath(buildpath string) string {
+	return filepath.Join(stateDir(), "history", projectKey(buildpath)+".json")
+}
+
+// beginCycle starts the record for a cycle triggered by the given files.
+func beginCycle(cycle int, trigger []string) *cycleRecord {
+	return &cycleRecord{
+		Cycle:   cycle,
+		Start:   time.Now(),
+		Trigger: trigger,
+	}
+}
+
+// finish fills in the result of the cycle and appends it to the history.
+func (rec *cycleRecord) finish(buildpath, result, output string) {
+	rec.Duration = time.Since(rec.Start)
+	rec.Result = result
+	rec.Error = errorSnippet(output)
+	rec.output = output
+	err := appendHistory(buildpath, rec)
+	if err != nil {
+		log.Printf("error writing history: %s", err)
+	}
+}
+
+func appendHistory(buildpath string, rec *cycleRecord) (err error) {
+	name := historyPath(buildpath)
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(rec)
+	return
+}
+
+func readHistory(buildpath string) (recs []cycleRecord, err error) {
+	f, err := os.Open(historyPath(buildpath))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec cycleRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			// a session that crashed mid-write leaves a partial line behind.
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	err = scanner.Err()
+	return
+}
+
+// showHistory prints the last n cycles recorded for buildpath.
+func showHistory(buildpath string, n int) (err error) {
+	recs, err := readHistory(buildpath)
+	if err != nil {
+		return
+	}
+	if n > 0 && len(recs) > n {
+		recs = recs[len(recs)-n:]
+	}
+	for _, rec := range recs {
+		fmt.Printf("%s  cycle %-4d %-14s %8s  %v\n", rec.Start.Format("2006-01-02 15:04:05"), rec.Cycle,
+			rec.Result, rec.Duration.Round(time.Millisecond), rec.Trigger)
+		if rec.Build != 0 {
+			fmt.Printf("    build %d\n", rec.Build)
+		}
+		if rec.Binary != "" {
+			fmt.Printf("    binary %s (%d bytes)\n", rec.Binary, rec.Size)
+		}
+		if rec.Go != "" {
+			fmt.Printf("    built with %s\n", rec.Go)
+		}
+		for _, line := range strings.Split(rec.Error, "\n") {
+			if line != "" {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+	return
+}
+
+// errorSnippet keeps the first few lines of a failure's output.
+func errorSnippet(output string) string {
+	lines := strings.SplitN(strings.TrimSpace(output), "\n", 6)
+	if len(lines) > 5 {
+		lines[5] = "..."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// hashFile returns the hex sha256 of the named file.
+func hashFile(name string) (sum string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	return
+}
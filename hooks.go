@@ -0,0 +1,111 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+var (
+	snapshot_hook    = flag.String("snapshot", "", "Shell command run right before the old program is stopped, to save its state into $RERUN_SCRATCH")
+	restore_hook     = flag.String("restore", "", "Shell command run right after the new program started, to load the state saved by --snapshot")
+	after_build_hook = flag.String("after-build", "", "Shell command run after every build, with its outcome in $RERUN_RESULT, e.g. to run database migrations")
+	after_start_hook = flag.String("after-start", "", "Shell command run once the new program is up, e.g. to warm caches or refresh a browser")
+)
+
+// shellCommand runs line through the platform's shell.
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return command("cmd", "/C", line)
+	}
+	return command("sh", "-c", line)
+}
+
+// runHook runs a hook in the package directory, with the program's
+// environment and env.
+func (s *session) runHook(name, line string, env ...string) (err error) {
+	cmd := shellCommand(line)
+	cmd.Dir = s.dir
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, s.childEnv()...)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		log.Printf("%s hook: %s", name, err)
+	}
+	return
+}
+
+// snapshot creates the scratch directory shared by the hooks of one restart
+// and runs --snapshot in it. It returns "" when there is nothing to restore.
+func (s *session) snapshot() (scratch string) {
+	if *snapshot_hook == "" && *restore_hook == "" {
+		return
+	}
+	scratch, err := ioutil.TempDir("", "rerun-scratch-")
+	if err != nil {
+		log.Printf("error creating scratch directory: %s", err)
+		return ""
+	}
+	if *snapshot_hook != "" && s.runHook("snapshot", *snapshot_hook, "RERUN_SCRATCH="+scratch) != nil {
+		// a failed snapshot may have left half a state behind; don't load it.
+		os.RemoveAll(scratch)
+		return ""
+	}
+	return
+}
+
+// restore runs --restore against what --snapshot saved, then removes the
+// scratch directory.
+func (s *session) restore(scratch string) {
+	if scratch == "" {
+		return
+	}
+	defer os.RemoveAll(scratch)
+	if *restore_hook != "" {
+		s.runHook("restore", *restore_hook, "RERUN_SCRATCH="+scratch)
+	}
+}
+
+// afterBuild runs --after-build once the build of rec is over, whatever its
+// outcome.
+func (s *session) afterBuild(rec *cycleRecord) {
+	if *after_build_hook == "" {
+		return
+	}
+	s.runHook("after-build", *after_build_hook,
+		"RERUN_RESULT="+rec.Result,
+		"RERUN_CYCLE="+strconv.Itoa(rec.Cycle),
+		"RERUN_BUILD_ID="+strconv.Itoa(rec.Build),
+		"RERUN_BINARY="+s.binPath)
+}
+
+// ready is called once the program started for l is up: when it answers
+// --warmup, or else when it started. --after-start runs in the background,
+// and the --livereload pages reload.
+func (s *session) ready(l launch, pid int) {
+	s.latency.ready(l.cycle, l.saved)
+	gate.request()
+	if *replay_count > 0 {
+		go s.replays.replay()
+	}
+	if *after_start_hook == "" {
+		return
+	}
+	go s.runHook("after-start", *after_start_hook,
+		"RERUN_CYCLE="+strconv.Itoa(l.cycle),
+		"RERUN_BUILD_ID="+strconv.Itoa(l.build),
+		"RERUN_PID="+strconv.Itoa(pid))
+}
@@ -0,0 +1,74 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// how much slower polling gets while idle.
+const idlePollFactor = 10
+
+// idle tracks whether anything has changed recently. While idle, rerun polls
+// less often and stops sampling the program's usage; everything else waits
+// for a change anyway.
+var idle = &idleState{last: time.Now()}
+
+type idleState struct {
+	mu   sync.Mutex
+	last time.Time
+	idle bool
+}
+
+// touch records activity, waking up from idle mode if needed.
+func (s *idleState) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = time.Now()
+	if s.idle {
+		s.idle = false
+		log.Println("waking up from idle mode")
+	}
+}
+
+func (s *idleState) isIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idle
+}
+
+// watch enters idle mode once nothing has changed for after.
+func (s *idleState) watch(ctx context.Context, after time.Duration) {
+	tick := after / 10
+	if tick > time.Minute {
+		tick = time.Minute
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		if !s.idle && time.Since(s.last) >= after {
+			s.idle = true
+			log.Printf("no changes for %s, entering idle mode", after)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pollEvery is the current polling interval.
+func pollEvery() time.Duration {
+	if idle.isIdle() {
+		return idlePollFactor * pollInterval
+	}
+	return pollInterval
+}
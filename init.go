@@ -0,0 +1,226 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// what scaffold found out about a project.
+type project struct {
+	mains     []string // import paths of main packages
+	tests     bool     // some package has _test.go files
+	templates []string // directories holding templates
+	protos    []string // directories holding .proto files
+	compose   string   // docker-compose file, if any
+	generated []string // directories that look generated
+}
+
+// inspect walks the project rooted at dir.
+func inspect(dir string) (p *project, err error) {
+	p = &project{}
+	seenTmpl := map[string]bool{}
+	seenProto := map[string]bool{}
+	err = filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		base := fi.Name()
+		if fi.IsDir() {
+			if name != dir && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") ||
+				base == "vendor" || base == "testdata" || base == "node_modules") {
+				return filepath.SkipDir
+			}
+			if pkg, err := build.ImportDir(name, 0); err == nil {
+				importpath := pkg.ImportPath
+				if importpath == "." {
+					// module mode: go/build doesn't know the import path.
+					importpath = moduleImportPath(name)
+				}
+				if pkg.Name == "main" && importpath != "" {
+					p.mains = append(p.mains, importpath)
+				}
+				if len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) > 0 {
+					p.tests = true
+				}
+			}
+			if base == "gen" || base == "generated" || strings.HasSuffix(base, "pb") {
+				p.generated = append(p.generated, rel(dir, name))
+			}
+			return nil
+		}
+		switch ext := filepath.Ext(base); {
+		case ext == ".tmpl" || ext == ".gohtml" || ext == ".html":
+			if d := rel(dir, filepath.Dir(name)); !seenTmpl[d] {
+				seenTmpl[d] = true
+				p.templates = append(p.templates, d)
+			}
+		case ext == ".proto":
+			if d := rel(dir, filepath.Dir(name)); !seenProto[d] {
+				seenProto[d] = true
+				p.protos = append(p.protos, d)
+			}
+		case base == "docker-compose.yml" || base == "docker-compose.yaml" || base == "compose.yaml":
+			if p.compose == "" {
+				p.compose = rel(dir, name)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// moduleImportPath is the import path of the package in dir, from the
+// module path in the go.mod above it, or "" outside a module.
+func moduleImportPath(dir string) string {
+	root := modRoot(dir)
+	if root == "" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			mod := strings.Trim(fields[1], `"`)
+			if r := filepath.ToSlash(rel(root, dir)); r != "." {
+				return mod + "/" + r
+			}
+			return mod
+		}
+	}
+	return ""
+}
+
+func rel(root, name string) string {
+	r, err := filepath.Rel(root, name)
+	if err != nil {
+		return name
+	}
+	return r
+}
+
+// choices are what a starter configuration sets, as rerun init guesses
+// them or the setup asks for them.
+type choices struct {
+	main  string
+	watch []string
+	test  bool
+	proxy string
+}
+
+func (p *project) defaults() (c choices) {
+	c.main = "<import path>"
+	if len(p.mains) > 0 {
+		c.main = p.mains[0]
+	}
+	c.test = p.tests
+	return
+}
+
+// starter renders a commented starter configuration for the project.
+func (p *project) starter() []byte {
+	return p.render(p.defaults(), "`rerun init`")
+}
+
+// render writes the configuration for c, with hints about the rest of the
+// project, and says by whom it was generated.
+func (p *project) render(c choices, by string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Starter rerun configuration generated by %s. Edit to taste.\n", by)
+	fmt.Fprintln(&b, "# Keys are rerun's flags; flags given on the command line take precedence.")
+	fmt.Fprintln(&b)
+	var others []string
+	for _, m := range p.mains {
+		if m != c.main {
+			others = append(others, m)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Fprintln(&b, "# Other main packages in this project:")
+		for _, m := range others {
+			fmt.Fprintf(&b, "#   %s\n", m)
+		}
+	}
+	fmt.Fprintf(&b, "path = %q\n", c.main)
+	fmt.Fprintln(&b, "# args = [\"--port\", \"8080\"]")
+	if c.test {
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "# Tests were found: run them every cycle and only restart when they pass.")
+		fmt.Fprintln(&b, "test = true")
+	}
+	if len(p.generated) > 0 {
+		fmt.Fprintln(&b)
+		var windows []string
+		for _, g := range p.generated {
+			fmt.Fprintf(&b, "# %s looks generated and may change in bursts: wait for it to settle.\n", g)
+			windows = append(windows, fmt.Sprintf("%q", g+"/*=1s"))
+		}
+		fmt.Fprintf(&b, "debounce-for = [%s]\n", strings.Join(windows, ", "))
+	}
+	if len(c.watch) > 0 {
+		fmt.Fprintln(&b)
+		var dirs []string
+		for _, d := range c.watch {
+			dirs = append(dirs, fmt.Sprintf("%q", d))
+		}
+		fmt.Fprintln(&b, "# Restart when these change too.")
+		fmt.Fprintf(&b, "watch = [%s]\n", strings.Join(dirs, ", "))
+	} else if len(p.templates) > 0 {
+		fmt.Fprintln(&b)
+		var dirs []string
+		for _, t := range p.templates {
+			fmt.Fprintf(&b, "# Templates live in %s; watch them to restart when they change.\n", t)
+			dirs = append(dirs, fmt.Sprintf("%q", t))
+		}
+		fmt.Fprintf(&b, "# watch = [%s]\n", strings.Join(dirs, ", "))
+	}
+	if c.proxy != "" {
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "# Hold requests while the program rebuilds.")
+		fmt.Fprintf(&b, "proxy = %q\n", c.proxy)
+	}
+	for _, d := range p.protos {
+		fmt.Fprintln(&b)
+		fmt.Fprintf(&b, "# .proto files live in %s; regenerate stubs before saving the Go side.\n", d)
+	}
+	if p.compose != "" {
+		fmt.Fprintln(&b)
+		fmt.Fprintf(&b, "# Services are defined in %s; start them with `docker compose up -d` first.\n", p.compose)
+	}
+	return b.Bytes()
+}
+
+// scaffold inspects the project in the current directory and writes a
+// starter configuration, without overwriting an existing one.
+func scaffold(name string) (err error) {
+	if _, err = os.Stat(name); err == nil {
+		return fmt.Errorf("%s already exists", name)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	p, err := inspect(wd)
+	if err != nil {
+		return
+	}
+	if len(p.mains) == 0 {
+		return fmt.Errorf("no main package found under %s", wd)
+	}
+	err = ioutil.WriteFile(name, p.starter(), 0644)
+	if err == nil {
+		fmt.Printf("wrote %s for %s\n", name, p.mains[0])
+	}
+	return
+}
@@ -0,0 +1,112 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// rerun's own counters, served on the control API under /debug/vars.
+var (
+	watchedDirs  = expvar.NewInt("watches")
+	polledDirs   = expvar.NewInt("polled")
+	eventsSeen   = expvar.NewInt("events")
+	eventsKept   = expvar.NewInt("events_kept")
+	recentEvents = &eventRate{}
+
+	// the sessions' cycle counts, queues and latencies, by program.
+	sessionCycles    = expvar.NewMap("cycle")
+	sessionQueues    = expvar.NewMap("queue")
+	sessionLatencies = expvar.NewMap("latency")
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("events_per_minute", expvar.Func(func() interface{} {
+		return recentEvents.lastMinute()
+	}))
+}
+
+// publish adds the session's cycle count, queue and latencies to
+// /debug/vars, under its program's name.
+func (s *session) publish() {
+	sessionCycles.Set(s.binName, expvar.Func(func() interface{} {
+		return s.loopStatus().Cycle
+	}))
+	sessionQueues.Set(s.binName, expvar.Func(func() interface{} {
+		return s.queue.status()
+	}))
+	sessionLatencies.Set(s.binName, expvar.Func(func() interface{} {
+		return s.latency.report()
+	}))
+}
+
+// eventRate counts events in one-second buckets over the last minute.
+type eventRate struct {
+	mu      sync.Mutex
+	buckets [60]int64
+	stamps  [60]int64
+}
+
+func (r *eventRate) add() {
+	now := time.Now().Unix()
+	i := now % 60
+	r.mu.Lock()
+	if r.stamps[i] != now {
+		r.stamps[i], r.buckets[i] = now, 0
+	}
+	r.buckets[i]++
+	r.mu.Unlock()
+}
+
+func (r *eventRate) lastMinute() (n int64) {
+	now := time.Now().Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, stamp := range r.stamps {
+		if now-stamp < 60 {
+			n += r.buckets[i]
+		}
+	}
+	return
+}
+
+// counted wraps filter to count the events it sees and keeps.
+func counted(filter watch.EventFilter) watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		eventsSeen.Add(1)
+		recentEvents.add()
+		if !filter.Keep(ctx, ev) {
+			return false
+		}
+		eventsKept.Add(1)
+		return true
+	})
+}
+
+// debugHandlers serves expvar and, unless the API is read-only, pprof on
+// mux. Profiles show rerun's command line and cost it CPU, which observers
+// don't get to decide.
+func debugHandlers(mux *http.ServeMux, readOnly bool) {
+	mux.Handle("/debug/vars", expvar.Handler())
+	if readOnly {
+		return
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,85 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A journalEntry is one line of the lifecycle journal.
+type journalEntry struct {
+	Time     time.Time     `json:"time"`
+	Event    string        `json:"event"`
+	Cycle    int           `json:"cycle,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Files    []string      `json:"files,omitempty"`
+	Result   string        `json:"result,omitempty"`
+}
+
+// A journal appends lifecycle records as JSON lines, for other tools to tail.
+// A nil *journal records nothing.
+type journal struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func journalPath(buildpath string) string {
+	return filepath.Join(stateDir(), "journal", projectKey(buildpath)+".json")
+}
+
+func openJournal(buildpath string) (j *journal, err error) {
+	name := journalPath(buildpath)
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	log.Printf("journal at %s", name)
+	j = &journal{f: f, enc: json.NewEncoder(f)}
+	return
+}
+
+func (j *journal) record(e journalEntry) {
+	if j == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	err := j.enc.Encode(e)
+	if err != nil {
+		log.Printf("error writing journal: %s", err)
+	}
+}
+
+// step records a pipeline step that began at start.
+func (j *journal) step(event string, cycle int, start time.Time, result string) {
+	j.record(journalEntry{
+		Event:    event,
+		Cycle:    cycle,
+		Duration: time.Since(start),
+		Result:   result,
+	})
+}
+
+func (j *journal) close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.f.Close()
+}
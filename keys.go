@@ -0,0 +1,192 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// keyMap binds the keys read from the terminal to actions.
+type keyMap map[byte]string
+
+var (
+	key_bindings = keyMap{}
+	read_keys    = flag.Bool("keys", true, "Read single-key commands from the terminal while watching (see --key)")
+)
+
+func init() {
+	flag.Var(key_bindings, "key", "Bind a key to an action while watching, as key=action: key is a character, space or ctrl-<letter>, action is rebuild, test, quit, clear, toggle:<label>, restart:<name> of a --proc or none to unbind a default (repeatable)")
+}
+
+// keyActions are what a key can do. An action ending in ':' takes an
+// argument.
+var keyActions = []string{"rebuild", "test", "quit", "clear", "toggle:", "restart:", "none"}
+
+// defaultKeys are bound unless --key binds the same keys.
+var defaultKeys = keyMap{'r': "rebuild", 't': "test", 'q': "quit", 'c': "clear"}
+
+func (m keyMap) String() string {
+	var parts []string
+	for k, action := range m {
+		parts = append(parts, keyName(k)+"="+action)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (m keyMap) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i == 0 {
+		// the key itself is '='.
+		i = strings.Index(value[1:], "=") + 1
+	}
+	if i <= 0 {
+		return fmt.Errorf("expected key=action, got %q", value)
+	}
+	k, err := parseKey(value[:i])
+	if err != nil {
+		return err
+	}
+	action := value[i+1:]
+	if !validAction(action) {
+		return fmt.Errorf("unknown action %q for key %s, expected one of %s", action, value[:i], strings.Join(keyActions, ", "))
+	}
+	m[k] = action
+	return nil
+}
+
+func validAction(action string) bool {
+	for _, a := range keyActions {
+		if action == a || strings.HasSuffix(a, ":") && strings.HasPrefix(action, a) && len(action) > len(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKey reads a key given as a character, space or ctrl-<letter>.
+func parseKey(name string) (k byte, err error) {
+	lower := strings.ToLower(name)
+	switch {
+	case len(name) == 1 && name[0] > ' ' && name[0] < 0x7f:
+		return name[0], nil
+	case lower == "space":
+		return ' ', nil
+	case len(lower) == 6 && strings.HasPrefix(lower, "ctrl-") && lower[5] >= 'a' && lower[5] <= 'z':
+		return lower[5] & 0x1f, nil
+	}
+	return 0, fmt.Errorf("bad key %q: use a character, space or ctrl-<letter>", name)
+}
+
+func keyName(k byte) string {
+	switch {
+	case k == ' ':
+		return "space"
+	case k < ' ':
+		return "ctrl-" + string(rune(k|0x60))
+	}
+	return string(rune(k))
+}
+
+var errNoTerminal = errors.New("stdin is not a terminal")
+
+// restoreTerminal undoes what reading keys did to the terminal.
+var restoreTerminal = func() {}
+
+// startKeys reads single keys from the terminal, if stdin is one, and acts
+// on the bound ones, for every session, until rerun exits.
+func startKeys(sessions []*session) {
+	if !*read_keys || *editor_stdio {
+		return
+	}
+	for k, action := range defaultKeys {
+		if _, ok := key_bindings[k]; !ok {
+			key_bindings[k] = action
+		}
+	}
+	for k, action := range key_bindings {
+		if action == "none" {
+			delete(key_bindings, k)
+		}
+	}
+	if len(key_bindings) == 0 {
+		return
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return
+	}
+	restore, err := rawTerminal()
+	if err == errNoTerminal {
+		return
+	}
+	if err != nil {
+		log.Printf("not reading keys: %s", err)
+		return
+	}
+	restoreTerminal = restore
+	log.Printf("keys: %s", key_bindings)
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			k, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			if action, ok := key_bindings[k]; ok {
+				keyAction(sessions, keyName(k), action)
+			}
+		}
+	}()
+}
+
+func keyAction(sessions []*session, key, action string) {
+	switch {
+	case action == "rebuild":
+		log.Printf("%s: rebuilding", key)
+		for _, s := range sessions {
+			s.restartRequested()
+		}
+	case action == "test":
+		log.Printf("%s: running the tests", key)
+		for _, s := range sessions {
+			s.testRequested()
+		}
+	case action == "quit":
+		log.Printf("%s: quitting", key)
+		for _, s := range sessions {
+			s.quit()
+		}
+	case strings.HasPrefix(action, "restart:"):
+		name := strings.TrimPrefix(action, "restart:")
+		for _, s := range sessions {
+			if s.name == name {
+				log.Printf("%s: restarting %s", key, name)
+				s.restartRequested()
+				return
+			}
+		}
+		log.Printf("%s: no program named %s", key, name)
+	case action == "clear":
+		fmt.Print("\033[H\033[2J")
+	case strings.HasPrefix(action, "toggle:"):
+		label := strings.TrimPrefix(action, "toggle:")
+		labels.set(label, !labels.on(label))
+	}
+}
+
+// testRequested runs the tests in the next cycle, even without --test, and
+// starts one.
+func (s *session) testRequested() {
+	atomic.StoreInt32(&s.testOnce, 1)
+	s.cycleRequested()
+}
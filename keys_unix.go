@@ -0,0 +1,38 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// rawTerminal hands keys to rerun as they are typed, without echoing them,
+// and returns how to undo that. Ctrl-C still interrupts.
+func rawTerminal() (restore func(), err error) {
+	saved, err := stty("-g")
+	if err != nil {
+		// such as /dev/null.
+		return nil, errNoTerminal
+	}
+	if _, err = stty("-icanon", "-echo", "min", "1"); err != nil {
+		return
+	}
+	return func() { stty(saved) }, nil
+}
+
+func stty(args ...string) (out string, err error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	b, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %s", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
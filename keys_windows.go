@@ -0,0 +1,10 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// rawTerminal leaves the console alone: keys arrive once enter is pressed.
+func rawTerminal() (restore func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// stages are the built-in steps of a cycle that can carry a label.
+var stages = []string{"assets", "escape", "test", "build", "bench"}
+
+// stageLabelList is a repeatable "stage=label" flag.
+type stageLabelList map[string]string
+
+func (l stageLabelList) String() string {
+	var parts []string
+	for stage, label := range l {
+		parts = append(parts, stage+"="+label)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (l stageLabelList) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 || !contains(stages, value[:i]) || value[i+1:] == "" {
+		return fmt.Errorf("expected stage=label with stage one of %s, got %q", strings.Join(stages, ", "), value)
+	}
+	l[value[:i]] = value[i+1:]
+	return nil
+}
+
+var (
+	stage_labels    = stageLabelList{}
+	disabled_labels stringList
+)
+
+func init() {
+	flag.Var(stage_labels, "label", "Tag a stage (assets, escape, test, build or bench) with a label, as stage=label (repeatable)")
+	flag.Var(&disabled_labels, "disable", "Start with the stages, rules and triggers labelled so turned off (repeatable)")
+}
+
+// labelled splits the optional "@label " prefix off a rule or trigger.
+func labelled(value string) (label, rest string) {
+	if !strings.HasPrefix(value, "@") {
+		return "", value
+	}
+	i := strings.Index(value, " ")
+	if i < 0 {
+		return "", value
+	}
+	return value[1:i], strings.TrimLeft(value[i:], " ")
+}
+
+// labelSet tracks which labels are turned on. Anything without a label is
+// always on.
+type labelSet struct {
+	mu      sync.Mutex
+	toggled map[string]bool
+}
+
+var labels = &labelSet{toggled: map[string]bool{}}
+
+func (l *labelSet) on(label string) bool {
+	if label == "" {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if on, ok := l.toggled[label]; ok {
+		return on
+	}
+	return !contains(disabled_labels, label)
+}
+
+// stageOn reports whether a built-in stage is turned on.
+func (l *labelSet) stageOn(stage string) bool {
+	return l.on(stage_labels[stage])
+}
+
+func (l *labelSet) set(label string, on bool) {
+	l.mu.Lock()
+	l.toggled[label] = on
+	l.mu.Unlock()
+	state := "off"
+	if on {
+		state = "on"
+	}
+	log.Printf("label %s turned %s", label, state)
+}
+
+// known lists every label in use, and whether it is on.
+func (l *labelSet) known() map[string]bool {
+	all := map[string]bool{}
+	for _, label := range stage_labels {
+		all[label] = l.on(label)
+	}
+	for _, r := range rules {
+		if r.Label != "" {
+			all[r.Label] = l.on(r.Label)
+		}
+	}
+	for _, t := range triggers {
+		if t.Label != "" {
+			all[t.Label] = l.on(t.Label)
+		}
+	}
+	for _, label := range disabled_labels {
+		all[label] = l.on(label)
+	}
+	return all
+}
+
+// serveLabels handles the labels part of the control API:
+//
+//	GET /labels	every label and whether it is on
+//	POST /labels/on?label=name	turn a label on
+//	POST /labels/off?label=name	turn it off
+func serveLabels(mux *http.ServeMux) {
+	mux.HandleFunc("/labels", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, labels.known())
+	})
+	for _, state := range []string{"on", "off"} {
+		on := state == "on"
+		mux.HandleFunc("/labels/"+state, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			label := r.FormValue("label")
+			if label == "" {
+				http.Error(w, "missing label", http.StatusBadRequest)
+				return
+			}
+			labels.set(label, on)
+			writeJSON(w, labels.known())
+		})
+	}
+}
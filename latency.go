@@ -0,0 +1,106 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// latencies measures how long rerun takes to respond to a save: until the
+// rebuild starts, which is mostly debouncing and queueing, and until the new
+// program is ready.
+type latencies struct {
+	sync.Mutex
+	toBuild []time.Duration
+	toReady []time.Duration
+}
+
+// latencyReport summarizes the latencies of a session.
+type latencyReport struct {
+	Cycles     int           `json:"cycles"`
+	BuildP50   time.Duration `json:"build_p50"`
+	BuildP95   time.Duration `json:"build_p95"`
+	Restarts   int           `json:"restarts"`
+	ReadyP50   time.Duration `json:"ready_p50,omitempty"`
+	ReadyP95   time.Duration `json:"ready_p95,omitempty"`
+	Debounce   time.Duration `json:"debounce"`
+	PolledDirs int64         `json:"polled_dirs"`
+}
+
+// savedAt is when the first change of a batch happened.
+func savedAt(batch []watch.Event) (t time.Time) {
+	for _, ev := range batch {
+		if !ev.Time.IsZero() && (t.IsZero() || ev.Time.Before(t)) {
+			t = ev.Time
+		}
+	}
+	return
+}
+
+func (l *latencies) building(saved, start time.Time) {
+	if saved.IsZero() {
+		return
+	}
+	l.Lock()
+	l.toBuild = append(l.toBuild, start.Sub(saved))
+	l.Unlock()
+}
+
+func (l *latencies) ready(cycle int, saved time.Time) {
+	if saved.IsZero() {
+		return
+	}
+	d := time.Since(saved)
+	l.Lock()
+	l.toReady = append(l.toReady, d)
+	l.Unlock()
+	log.Printf("cycle %d ready %s after the save", cycle, d.Round(time.Millisecond))
+}
+
+// percentile returns the p-th percentile of ds, by the nearest rank.
+func percentile(ds []time.Duration, p int) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func (l *latencies) report() *latencyReport {
+	l.Lock()
+	defer l.Unlock()
+	return &latencyReport{
+		Cycles:     len(l.toBuild),
+		BuildP50:   percentile(l.toBuild, 50),
+		BuildP95:   percentile(l.toBuild, 95),
+		Restarts:   len(l.toReady),
+		ReadyP50:   percentile(l.toReady, 50),
+		ReadyP95:   percentile(l.toReady, 95),
+		Debounce:   *debounce,
+		PolledDirs: polledDirs.Value(),
+	}
+}
+
+// logReport logs the latencies of the session, if there were any.
+func (l *latencies) logReport() {
+	r := l.report()
+	if r.Cycles == 0 {
+		return
+	}
+	log.Printf("save to rebuild over %d cycles: p50 %s, p95 %s", r.Cycles, r.BuildP50.Round(time.Millisecond), r.BuildP95.Round(time.Millisecond))
+	if r.Restarts > 0 {
+		log.Printf("save to ready over %d restarts: p50 %s, p95 %s", r.Restarts, r.ReadyP50.Round(time.Millisecond), r.ReadyP95.Round(time.Millisecond))
+	}
+}
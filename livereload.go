@@ -0,0 +1,306 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var livereload_addr = flag.String("livereload", "", "Run a LiveReload server on this address, e.g. localhost:35729, and reload the connected browsers whenever a new build of the program is up")
+
+// liveReloadScript connects a page to the server it was loaded from and
+// reloads the page when told to, reconnecting while rerun restarts. It
+// shows the failures of the last cycles over the page.
+const liveReloadScript = `(function() {
+	var src = document.currentScript.src;
+	var url = src.replace(/^http/, "ws").replace(/\/livereload\.js.*$/, "/livereload");
+	var failures = {};
+	function overlay(msg) {
+		if (msg.title) {
+			failures[msg.name] = msg;
+		} else {
+			delete failures[msg.name];
+		}
+		var el = document.getElementById("rerun-overlay");
+		if (el) {
+			el.remove();
+		}
+		var names = Object.keys(failures);
+		if (names.length === 0) {
+			return;
+		}
+		el = document.createElement("div");
+		el.id = "rerun-overlay";
+		el.style.cssText = "position:fixed;inset:0;z-index:2147483647;overflow:auto;padding:2em;background:rgba(30,30,30,0.95);color:#eee;font-family:sans-serif";
+		names.forEach(function(name) {
+			var h = document.createElement("h1");
+			h.style.cssText = "font-size:1.2em;color:#ff6b6b";
+			h.textContent = failures[name].title;
+			var pre = document.createElement("pre");
+			pre.style.cssText = "white-space:pre-wrap;font-size:0.9em;line-height:1.4";
+			pre.textContent = failures[name].output;
+			el.appendChild(h);
+			el.appendChild(pre);
+		});
+		document.body.appendChild(el);
+	}
+	function connect() {
+		var ws = new WebSocket(url);
+		ws.onopen = function() {
+			ws.send(JSON.stringify({command: "hello", protocols: ["http://livereload.com/protocols/official-7"]}));
+		};
+		ws.onmessage = function(ev) {
+			var msg = JSON.parse(ev.data);
+			if (msg.command === "reload") {
+				location.reload();
+			} else if (msg.command === "overlay") {
+				overlay(msg);
+			}
+		};
+		ws.onclose = function() {
+			setTimeout(connect, 1000);
+		};
+	}
+	connect();
+})();
+`
+
+// liveReloadProtocol is the version of the LiveReload protocol spoken, which
+// browser extensions and livereload.js both understand.
+const liveReloadProtocol = "http://livereload.com/protocols/official-7"
+
+// browsers are the pages connected to the LiveReload server, or nil without
+// --livereload.
+var browsers *liveReload
+
+type liveReload struct {
+	mu       sync.Mutex
+	conns    map[*wsConn]bool
+	sessions []*session
+}
+
+// startLiveReload listens on addr for browsers, which connect with a browser
+// extension or by loading /livereload.js.
+func startLiveReload(addr string, sessions []*session) (err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	lr := &liveReload{conns: map[*wsConn]bool{}, sessions: sessions}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload", lr.serve)
+	mux.HandleFunc("/livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		io.WriteString(w, liveReloadScript)
+	})
+	browsers = lr
+	log.Printf("live reload on %s, add <script src=\"http://%s/livereload.js\"></script> to the page", ln.Addr(), ln.Addr())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("live reload: %s", err)
+		}
+	}()
+	return
+}
+
+// serve upgrades r to a WebSocket and answers the browser's hello, with the
+// failures it should show, until it goes away.
+func (lr *liveReload) serve(w http.ResponseWriter, r *http.Request) {
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer c.Close()
+	lr.mu.Lock()
+	lr.conns[c] = true
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.conns, c)
+		lr.mu.Unlock()
+	}()
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return
+		}
+		var cmd struct {
+			Command string `json:"command"`
+		}
+		if json.Unmarshal(msg, &cmd) == nil && cmd.Command == "hello" {
+			c.writeJSON(map[string]interface{}{
+				"command":    "hello",
+				"protocols":  []string{liveReloadProtocol},
+				"serverName": "rerun",
+			})
+			for _, s := range lr.sessions {
+				if title, _ := s.overlay.get(); title != "" {
+					c.writeJSON(s.overlay.message(s.binName))
+				}
+			}
+		}
+	}
+}
+
+// reloadBrowsers tells every connected page to reload. It does nothing
+// without --livereload.
+func reloadBrowsers() {
+	if browsers == nil {
+		return
+	}
+	browsers.mu.Lock()
+	defer browsers.mu.Unlock()
+	if len(browsers.conns) == 0 {
+		return
+	}
+	log.Printf("reloading %d browser page(s)", len(browsers.conns))
+	for c := range browsers.conns {
+		c.writeJSON(map[string]interface{}{
+			"command": "reload",
+			"path":    "/",
+			"liveCSS": false,
+		})
+	}
+}
+
+// send passes msg to every connected page. It does nothing without
+// --livereload.
+func (lr *liveReload) send(msg map[string]interface{}) {
+	if lr == nil {
+		return
+	}
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for c := range lr.conns {
+		c.writeJSON(msg)
+	}
+}
+
+// wsConn is the server end of a WebSocket, as much of RFC 6455 as live
+// reload needs: unfragmented text messages, pings and closing.
+type wsConn struct {
+	net.Conn
+	r  *bufio.Reader
+	mu sync.Mutex
+}
+
+// wsGUID is what RFC 6455 appends to the client's key to accept it.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (c *wsConn, err error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		return nil, errors.New("expected a WebSocket upgrade")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("can't take over the connection")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	return &wsConn{Conn: conn, r: rw.Reader}, nil
+}
+
+// read returns the next text message, answering pings on the way.
+func (c *wsConn) read() (msg []byte, err error) {
+	for {
+		var head [2]byte
+		if _, err = io.ReadFull(c.r, head[:]); err != nil {
+			return
+		}
+		op := head[0] & 0x0f
+		n := uint64(head[1] & 0x7f)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > 1<<20 {
+			return nil, errors.New("message too large")
+		}
+		var mask [4]byte
+		if head[1]&0x80 != 0 {
+			if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+				return
+			}
+		}
+		payload := make([]byte, n)
+		if _, err = io.ReadFull(c.r, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		switch op {
+		case 0x1:
+			return payload, nil
+		case 0x8:
+			c.write(0x8, nil)
+			return nil, io.EOF
+		case 0x9:
+			c.write(0xa, payload)
+		}
+	}
+}
+
+func (c *wsConn) write(op byte, payload []byte) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n < 1<<16:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	if _, err = c.Write(head); err != nil {
+		return
+	}
+	_, err = c.Write(payload)
+	return
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(0x1, data)
+}
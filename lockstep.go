@@ -0,0 +1,141 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var lockstep = flag.Bool("lockstep", false, "Hold browser reloads, and with --standby the switch to a new build, until the program and every --builder have finished with the same changes, and both succeeded")
+
+// reloadGate decides when the pages reload. With --lockstep, a reload waits
+// until the go side and the builders have both settled, so that the browser
+// never sees new assets against the old program or the other way around.
+type reloadGate struct {
+	mu       sync.Mutex
+	wanted   bool
+	sessions []*session
+}
+
+var gate reloadGate
+
+// request asks for the pages to reload, now or, with --lockstep, once
+// everything has settled.
+func (g *reloadGate) request() {
+	if !*lockstep {
+		reloadBrowsers()
+		return
+	}
+	g.mu.Lock()
+	g.wanted = true
+	g.mu.Unlock()
+	g.check()
+}
+
+// check reloads the pages if a reload is wanted and nothing is building,
+// queued or starting. If something failed, the reload is dropped: the next
+// successful build asks again.
+func (g *reloadGate) check() {
+	if !*lockstep {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.wanted || !settled(g.sessions) {
+		return
+	}
+	g.wanted = false
+	if failed := lockstepFailures(g.sessions); len(failed) > 0 {
+		log.Printf("not reloading the pages, %s failed", strings.Join(failed, " and "))
+		return
+	}
+	reloadBrowsers()
+}
+
+// settled reports whether every builder is idle, and every session has no
+// cycle running or waiting and no program starting.
+func settled(sessions []*session) bool {
+	for _, b := range builders {
+		if !b.idle() {
+			return false
+		}
+	}
+	for _, s := range sessions {
+		if atomic.LoadInt32(&s.launching) > 0 {
+			return false
+		}
+		if st := s.queue.status(); st.Building != nil || st.Pending != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lockstepFailures names the builders and programs whose last run failed.
+func lockstepFailures(sessions []*session) (failed []string) {
+	for _, b := range builders {
+		if !b.ready() {
+			failed = append(failed, "builder "+b.Name)
+		}
+	}
+	for _, s := range sessions {
+		if atomic.LoadInt32(&s.broken) == 1 {
+			failed = append(failed, s.binName)
+		}
+	}
+	return
+}
+
+// cycleDone records how the last cycle went, for --lockstep, the overlay
+// and the control API.
+func (s *session) cycleDone() {
+	s.publishStatus()
+	var broken int32
+	if s.last != nil && s.last.Result != "ok" {
+		broken = 1
+	}
+	atomic.StoreInt32(&s.broken, broken)
+	if s.last != nil && s.overlay.update(s.binName, s.last) {
+		browsers.send(s.overlay.message(s.binName))
+	}
+	gate.check()
+}
+
+// launchDone marks a launch the loop sent as handled, whether the program
+// came up or not.
+func (s *session) launchDone() {
+	atomic.AddInt32(&s.launching, -1)
+	gate.check()
+}
+
+// waitLockstep holds the switch to a new build, with --lockstep and
+// --standby, until the builders are idle, for at most --standby-timeout.
+func waitLockstep(build int) {
+	if !*lockstep || len(builders) == 0 {
+		return
+	}
+	deadline := time.Now().Add(*standby_timeout)
+	for {
+		idle := true
+		for _, b := range builders {
+			if !b.idle() {
+				idle = false
+			}
+		}
+		if idle {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("the builders are still busy after %s, switching to build %d anyway", *standby_timeout, build)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// keptLines is how many recent lines the log hub remembers, and
+// maxCapture how large a program's capture file grows before it is
+// rotated.
+const (
+	keptLines  = 1000
+	maxCapture = 64 << 20
+)
+
+// A logLine is one line of rerun's or the program's output.
+type logLine struct {
+	Time    time.Time
+	Cycle   int    `json:",omitempty"`
+	Build   int    `json:",omitempty"`
+	Process string `json:",omitempty"`
+	Source  string
+	Line    string
+}
+
+// logHub collects rerun's log and the programs' output for the control API,
+// and captures each program's output for rerun search.
+type logHub struct {
+	mu       sync.Mutex
+	recent   []logLine
+	subs     map[chan logLine]bool
+	captures map[string]*capture // by process
+}
+
+// A capture is the file one program's output is kept in.
+type capture struct {
+	f    *os.File
+	path string
+	size int64
+}
+
+var logs = &logHub{subs: map[chan logLine]bool{}, captures: map[string]*capture{}}
+
+// capturePath is where the output of the current or last session for
+// buildpath is kept.
+func capturePath(buildpath string) string {
+	return filepath.Join(stateDir(), "output", projectKey(buildpath)+".json")
+}
+
+// captureTo starts a fresh capture of the output of process at path.
+func (h *logHub) captureTo(process, path string) (err error) {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.Remove(path + ".old")
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old := h.captures[process]; old != nil {
+		old.f.Close()
+	}
+	h.captures[process] = &capture{f: f, path: path}
+	return
+}
+
+// write appends l to the capture file of its process, which must be
+// locked.
+func (h *logHub) write(l logLine) {
+	c := h.captures[l.Process]
+	if c == nil || l.Cycle == 0 {
+		return
+	}
+	data, _ := json.Marshal(l)
+	n, err := c.f.Write(append(data, '\n'))
+	c.size += int64(n)
+	if err != nil {
+		log.Printf("error capturing output: %s", err)
+		c.f.Close()
+		delete(h.captures, l.Process)
+		return
+	}
+	if c.size > maxCapture {
+		// keep one older file around, so a search still reaches back a bit.
+		c.f.Close()
+		os.Rename(c.path, c.path+".old")
+		c.f, err = os.Create(c.path)
+		c.size = 0
+		if err != nil {
+			log.Printf("error capturing output: %s", err)
+			delete(h.captures, l.Process)
+		}
+	}
+}
+
+// add records a line. Only the program's lines, which carry the cycle
+// that started it, are captured.
+func (h *logHub) add(l logLine) {
+	l.Time = time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.write(l)
+	h.recent = append(h.recent, l)
+	if len(h.recent) > keptLines {
+		h.recent = h.recent[len(h.recent)-keptLines:]
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- l:
+		default:
+			// a subscriber that can't keep up misses lines rather than
+			// holding up the program.
+		}
+	}
+}
+
+// subscribe returns the recent lines and a channel carrying the ones that
+// follow, until cancel is called.
+func (h *logHub) subscribe() (recent []logLine, ch chan logLine, cancel func()) {
+	ch = make(chan logLine, 256)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recent = append(recent, h.recent...)
+	h.subs[ch] = true
+	cancel = func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+	return
+}
+
+// hubWriter feeds everything written to it into the log hub.
+func hubWriter(source string) *lineWriter {
+	return &lineWriter{out: ioutil.Discard, line: func(line string) {
+		logs.add(logLine{Source: source, Line: line})
+	}}
+}
+
+// output is where the program's output goes on its way to out: through the
+// output view and the --on triggers, and into the log hub.
+func (s *session) output(out io.Writer, source string, l launch) io.Writer {
+	return &lineWriter{out: out, process: s.binName, prefix: outputPrefix(s.binName), line: func(line string) {
+		logs.add(logLine{Cycle: l.cycle, Build: l.build, Process: s.binName, Source: source, Line: line})
+		s.matchTriggers(line)
+		s.readyLine.saw(line)
+	}}
+}
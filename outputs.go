@@ -0,0 +1,103 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// A buildOutput is a file or directory rerun writes itself. Inside a watched
+// directory, every cycle would change it and start the next one.
+type buildOutput struct {
+	path   string
+	what   string
+	prefix bool // everything whose name starts with path
+}
+
+func (s *session) buildOutputs() (outs []buildOutput) {
+	if !*prebuilt && *exec_cmd == "" {
+		outs = append(outs, buildOutput{path: s.binPath, what: "the program's binary"})
+	}
+	if *assets_out != "" {
+		if abs, err := filepath.Abs(*assets_out); err == nil {
+			outs = append(outs, buildOutput{path: abs, what: "the fingerprinted assets"})
+		}
+	}
+	outs = append(outs,
+		buildOutput{path: stateDir(), what: "rerun's history and logs"},
+		buildOutput{path: filepath.Join(os.TempDir(), "rerun-"), what: "rerun's temporary files", prefix: true})
+	if noBuild() {
+		return
+	}
+	// the go command's work directories and its cache.
+	tmp := os.Getenv("GOTMPDIR")
+	if tmp == "" {
+		tmp = os.TempDir()
+	}
+	outs = append(outs, buildOutput{path: filepath.Join(tmp, "go-build"), what: "the go command's work directories", prefix: true})
+	cache := os.Getenv("GOCACHE")
+	if cache == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cache = filepath.Join(dir, "go-build")
+		}
+	}
+	if cache != "" && cache != "off" {
+		outs = append(outs, buildOutput{path: filepath.Clean(cache), what: "the go build cache"})
+	}
+	return
+}
+
+// watched reports whether changes to out would show up in one of the
+// watched directories.
+func (out buildOutput) watched(dirs []string) bool {
+	for _, dir := range dirs {
+		if dir == filepath.Dir(out.path) || out.covers(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether name is out or lies below it.
+func (out buildOutput) covers(name string) bool {
+	if out.prefix {
+		return strings.HasPrefix(name, out.path)
+	}
+	return name == out.path || strings.HasPrefix(name, out.path+string(filepath.Separator))
+}
+
+// outputFilter drops the changes to what rerun writes inside the watched
+// directories, after warning about each. Explicit saves are kept.
+func (s *session) outputFilter() watch.EventFilter {
+	dirs := s.watchDirs()
+	var ignored []buildOutput
+	for _, out := range s.buildOutputs() {
+		if out.watched(dirs) {
+			name := out.path
+			if out.prefix {
+				name += "*"
+			}
+			log.Printf("warning: %s, %s, is inside a watched directory; ignoring changes to it, which would otherwise rebuild in a loop", out.what, name)
+			ignored = append(ignored, out)
+		}
+	}
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if ev.Op&watch.Save != 0 {
+			return true
+		}
+		for _, out := range ignored {
+			if out.covers(ev.Name) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// overlay is the failure of the last cycle, which --proxy shows instead of
+// the program's pages and --livereload shows over them until a cycle
+// succeeds again.
+type overlay struct {
+	mu     sync.Mutex
+	title  string // "" while nothing failed
+	output string
+}
+
+// update takes the outcome of rec, and reports whether that changed what
+// is shown.
+func (o *overlay) update(name string, rec *cycleRecord) (changed bool) {
+	var title, output string
+	switch rec.Result {
+	case "ok":
+	case "preempted":
+		// another cycle follows at once.
+		return false
+	default:
+		title = fmt.Sprintf("%s: %s in cycle %d (build %d)", name, rec.Result, rec.Cycle, rec.Build)
+		output = rec.output
+		if output == "" {
+			output = rec.Error
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	changed = title != o.title || output != o.output
+	o.title, o.output = title, output
+	return
+}
+
+func (o *overlay) get() (title, output string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.title, o.output
+}
+
+// message is what --livereload sends the pages about the failure: an empty
+// title takes the overlay away.
+func (o *overlay) message(name string) map[string]interface{} {
+	title, output := o.get()
+	return map[string]interface{}{
+		"command": "overlay",
+		"name":    name,
+		"title":   title,
+		"output":  output,
+	}
+}
+
+// overlayPath is where the page --proxy serves in place of the program's
+// asks whether the failure is still there.
+const overlayPath = "/_rerun/overlay"
+
+// overlayPage is served for pages while the last cycle failed. It reloads
+// itself once a cycle succeeds.
+const overlayPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; padding: 2em; background: #1e1e1e; color: #eee; font-family: sans-serif; }
+h1 { font-size: 1.2em; color: #ff6b6b; }
+pre { white-space: pre-wrap; font-size: 0.9em; line-height: 1.4; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+<pre>%s</pre>
+<script>
+setInterval(function() {
+	fetch(%q).then(function(r) {
+		if (r.status === 204) {
+			location.reload();
+		}
+	});
+}, 1000);
+</script>
+</body>
+</html>
+`
+
+// serveOverlay answers for the program while its last cycle failed: pages
+// get the failure, and overlayPath whether it is still there. It reports
+// whether it answered; everything else goes to the program.
+func (s *session) serveOverlay(w http.ResponseWriter, r *http.Request) bool {
+	title, output := s.overlay.get()
+	if r.URL.Path == overlayPath {
+		if title == "" {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		return true
+	}
+	if title == "" || r.Method != "GET" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	title = html.EscapeString(title)
+	fmt.Fprintf(w, overlayPage, title, title, html.EscapeString(output), overlayPath)
+	return true
+}
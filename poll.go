@@ -0,0 +1,91 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// how often polled directories are listed, when not idle.
+const pollInterval = time.Second
+
+type fileState struct {
+	mod  time.Time
+	size int64
+}
+
+// a poller notices changes in directories by listing them periodically, for
+// file systems where native events are unreliable.
+type poller struct {
+	dirs   []string
+	events chan<- watch.Event
+	done   chan bool
+}
+
+func newPoller(dirs []string, events chan<- watch.Event) *poller {
+	p := &poller{dirs: dirs, events: events, done: make(chan bool)}
+	go p.loop()
+	return p
+}
+
+func (p *poller) Close() error {
+	close(p.done)
+	return nil
+}
+
+func (p *poller) snapshot() map[string]fileState {
+	files := map[string]fileState{}
+	for _, dir := range p.dirs {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if fi.IsDir() {
+				continue
+			}
+			files[filepath.Join(dir, fi.Name())] = fileState{fi.ModTime(), fi.Size()}
+		}
+	}
+	return files
+}
+
+func (p *poller) loop() {
+	last := p.snapshot()
+	for {
+		select {
+		case <-time.After(pollEvery()):
+		case <-p.done:
+			return
+		}
+		now := p.snapshot()
+		for name, st := range now {
+			old, ok := last[name]
+			switch {
+			case !ok:
+				p.send(name, watch.Create)
+			case old != st:
+				p.send(name, watch.Write)
+			}
+		}
+		for name := range last {
+			if _, ok := now[name]; !ok {
+				p.send(name, watch.Remove)
+			}
+		}
+		last = now
+	}
+}
+
+func (p *poller) send(name string, op watch.Op) {
+	select {
+	case p.events <- watch.Event{Name: name, Op: op, Time: time.Now()}:
+	case <-p.done:
+	}
+}
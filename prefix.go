@@ -0,0 +1,75 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	prefix_output = flag.Bool("prefix", false, "Start every line of the program's output, and of the sidecars' and builders', with the name of the process, as is done with several programs")
+	color_mode    = flag.String("color", "auto", "Color the names --prefix writes: auto (when writing to a terminal and NO_COLOR is unset), always or never")
+	timestamps    = flag.Bool("timestamps", false, "Start every line of the program's output with the time it was written")
+)
+
+// prefixColors are the ANSI colors handed out to processes in turn.
+var prefixColors = []string{"36", "33", "32", "35", "34", "31"}
+
+var prefixes = struct {
+	sync.Mutex
+	width  int
+	colors map[string]string
+}{colors: map[string]string{}}
+
+// registerPrefixes hands out colors to the processes whose output is
+// prefixed, and pads the names to the longest.
+func registerPrefixes(names ...string) {
+	prefixes.Lock()
+	defer prefixes.Unlock()
+	for _, name := range names {
+		if _, ok := prefixes.colors[name]; ok {
+			continue
+		}
+		prefixes.colors[name] = prefixColors[len(prefixes.colors)%len(prefixColors)]
+		if len(name) > prefixes.width {
+			prefixes.width = len(name)
+		}
+	}
+}
+
+// outputPrefix goes before every line of the output of the process name, if
+// output is prefixed.
+func outputPrefix(name string) string {
+	if !*prefix_output && !multiProcess() {
+		return ""
+	}
+	registerPrefixes(name)
+	prefixes.Lock()
+	padded := name + strings.Repeat(" ", prefixes.width-len(name))
+	color := prefixes.colors[name]
+	prefixes.Unlock()
+	if !useColor() {
+		return padded + " | "
+	}
+	return "\033[" + color + "m" + padded + " |\033[0m "
+}
+
+func useColor() bool {
+	switch *color_mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || runtime.GOOS == "windows" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,67 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os/exec"
+)
+
+var priority_globs stringList
+
+func init() {
+	flag.Var(&priority_globs, "priority", "Changes to files matching this glob go in the priority lane: they skip --rate-limit and cut short a running cycle started by other changes (repeatable)")
+}
+
+// The lanes a batch of changes can take.
+const (
+	laneNormal   = ""
+	lanePriority = "priority"
+)
+
+// laneOf is the lane of a batch of changes to files: priority if any of
+// them matches --priority.
+func laneOf(files []string) string {
+	for _, name := range files {
+		if matchesAny(priority_globs, globName(name)) {
+			return lanePriority
+		}
+	}
+	return laneNormal
+}
+
+// preempted reports whether the cycle's context is done, recording the cycle
+// as cut short if so.
+func (s *session) preempted(ctx context.Context, rec *cycleRecord, why *cycleReason) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	rec.finish(s.buildpath, "preempted", "")
+	why.act("preempted")
+	return true
+}
+
+// runCycleCmd runs cmd, a step of a cycle, killing it along with whatever it
+// started (such as a test binary) once ctx is done.
+func runCycleCmd(ctx context.Context, cmd *exec.Cmd) (err error) {
+	ownGroup(cmd)
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	joinGroup(cmd.Process)
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killGroup(cmd.Process)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	leaveGroup(cmd.Process)
+	return
+}
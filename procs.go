@@ -0,0 +1,138 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A proc is one of several programs rerun watches, rebuilds and reruns at
+// once.
+type proc struct {
+	Name string
+	Path string
+	Args []string
+}
+
+type procList []proc
+
+func (l *procList) String() string {
+	var parts []string
+	for _, p := range *l {
+		parts = append(parts, p.Name+"="+p.Path)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set takes name=importpath[:args].
+func (l *procList) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return errors.New("expected name=importpath[:args]")
+	}
+	p := proc{Name: value[:i], Path: value[i+1:]}
+	if j := strings.Index(p.Path, ":"); j >= 0 {
+		var args argsFlag
+		if err := args.Set(p.Path[j+1:]); err != nil {
+			return err
+		}
+		p.Path, p.Args = p.Path[:j], args
+	}
+	*l = append(*l, p)
+	return nil
+}
+
+var (
+	procs     procList
+	proc_file = flag.String("procfile", "", "Run the programs listed in this file, one name: importpath [arg]* line each, like --proc")
+)
+
+func init() {
+	flag.Var(&procs, "proc", "Run this program alongside the others, as name=importpath[:args]; each has its own watches and restarts, and its output is prefixed with its name (repeatable)")
+}
+
+// procName keeps names usable in output prefixes and control API paths.
+var procName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// readProcfile reads name: importpath [arg]* lines, skipping blank lines and
+// # comments.
+func readProcfile(name string) (list procList, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected name: importpath [arg]*", name, n)
+		}
+		var fields argsFlag
+		if err = fields.Set(line[i+1:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, n, err)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%s:%d: no import path for %s", name, n, line[:i])
+		}
+		list = append(list, proc{Name: strings.TrimSpace(line[:i]), Path: fields[0], Args: fields[1:]})
+	}
+	return list, scanner.Err()
+}
+
+// multiProcess reports whether rerun runs several programs.
+func multiProcess() bool {
+	return *proc_file != "" || len(procs) > 0
+}
+
+// runProcs runs every --proc and --procfile program in a session of its own.
+func runProcs(ctx context.Context) (err error) {
+	list := procs
+	if *proc_file != "" {
+		var more procList
+		if more, err = readProcfile(*proc_file); err != nil {
+			return
+		}
+		list = append(append(procList{}, list...), more...)
+	}
+	// these act on the one program, or take something only one can have.
+	for _, name := range []string{"exec", "prebuilt", "containerize", "emulator", "standby", "proxy", "output", "editor", "fifo", "lead", "follow"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() != f.DefValue {
+			return fmt.Errorf("--%s does not work with --proc or --procfile", name)
+		}
+	}
+	seen := map[string]bool{}
+	for _, p := range list {
+		if !procName.MatchString(p.Name) {
+			return fmt.Errorf("bad program name %q: use letters, digits, '.', '_' and '-'", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("two programs are named %s", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	var sessions []*session
+	for _, p := range list {
+		log.Printf("setting up %s: %s %v", p.Name, p.Path, p.Args)
+		s, serr := newSession(p.Name, p.Path, p.Args)
+		if serr != nil {
+			return fmt.Errorf("%s: %s", p.Name, serr)
+		}
+		sessions = append(sessions, s)
+	}
+	return runSessions(ctx, sessions)
+}
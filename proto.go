@@ -0,0 +1,179 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var proto_gen = flag.String("proto", "", "Regenerate stubs when .proto files change, in the main module or a module it replaces with a local directory: a command run in that module's root, or auto")
+
+// modRoot finds the root of the module dir belongs to.
+func modRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// protoModules are the roots of the main module and of the modules it
+// replaces with local directories, where .proto files may live.
+func (s *session) protoModules() (roots []string) {
+	root := modRoot(s.dir)
+	if root == "" {
+		return
+	}
+	roots = append(roots, root)
+	f, err := os.Open(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	inBlock := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "replace (":
+			inBlock = true
+			continue
+		case inBlock && line == ")":
+			inBlock = false
+			continue
+		case strings.HasPrefix(line, "replace "):
+			line = strings.TrimPrefix(line, "replace ")
+		case !inBlock:
+			continue
+		}
+		i := strings.Index(line, "=>")
+		if i < 0 {
+			continue
+		}
+		target := strings.Fields(line[i+2:])
+		if len(target) != 1 {
+			// a module path and version, not a directory.
+			continue
+		}
+		dir := target[0]
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		roots = append(roots, filepath.Clean(dir))
+	}
+	return
+}
+
+// protoDirs are the directories holding .proto files in the proto modules.
+func (s *session) protoDirs() (dirs []string) {
+	if *proto_gen == "" {
+		return
+	}
+	for _, root := range s.protoModules() {
+		seen := map[string]bool{}
+		filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if fi.IsDir() {
+				if name != root && skipDir(name) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if dir := filepath.Dir(name); filepath.Ext(name) == ".proto" && !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+			return nil
+		})
+	}
+	return
+}
+
+// protoFilter keeps changes to .proto files.
+func protoFilter() watch.EventFilter {
+	if *proto_gen == "" {
+		return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+			return false
+		})
+	}
+	return watch.Ext(".proto")
+}
+
+// protoCommand is what regenerates the stubs of the module at root.
+func protoCommand(root string) string {
+	if *proto_gen != "auto" {
+		return *proto_gen
+	}
+	for _, name := range []string{"buf.gen.yaml", "buf.gen.yml"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			return "buf generate"
+		}
+	}
+	return "go generate ./..."
+}
+
+// regenerate runs the generator in the root of every proto module that has
+// changed .proto files, before the program is rebuilt against the new
+// stubs. It reports whether all of them succeeded.
+func (s *session) regenerate(rec *cycleRecord, why *cycleReason, changed []string) (ok bool) {
+	if *proto_gen == "" {
+		return true
+	}
+	roots := s.protoModules()
+	todo := map[string]bool{}
+	for _, name := range changed {
+		if filepath.Ext(name) != ".proto" {
+			continue
+		}
+		// the innermost module wins, a replaced module may live inside the main one.
+		best := ""
+		for _, root := range roots {
+			if strings.HasPrefix(name, root+string(filepath.Separator)) && len(root) > len(best) {
+				best = root
+			}
+		}
+		if best != "" {
+			todo[best] = true
+		}
+	}
+	var order []string
+	for root := range todo {
+		order = append(order, root)
+	}
+	sort.Strings(order)
+	for _, root := range order {
+		line := protoCommand(root)
+		start := time.Now()
+		log.Printf("regenerating stubs in %s: %s", root, line)
+		cmd := shellCommand(line)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Printf("error regenerating stubs in %s: %s\n%s", root, err, out)
+			s.step(why, "proto "+root, start, "failed")
+			rec.finish(s.buildpath, "generator failure", string(out))
+			return
+		}
+		s.step(why, "proto "+root, start, "ok")
+	}
+	return true
+}
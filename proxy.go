@@ -0,0 +1,133 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	proxy_spec    = flag.String("proxy", "", "Proxy HTTP to the program as listen->target, e.g. :3000->:8080, holding requests while it rebuilds and restarts")
+	proxy_timeout = flag.Duration("proxy-timeout", 30*time.Second, "With --proxy, how long a request is held for the program before it fails")
+)
+
+// parseProxy splits a --proxy spec into the address to listen on and the
+// one the program listens on.
+func parseProxy(spec string) (listen, target string, err error) {
+	i := strings.Index(spec, "->")
+	if i <= 0 || i == len(spec)-2 {
+		return "", "", fmt.Errorf("bad --proxy %q: expected listen->target, e.g. :3000->:8080", spec)
+	}
+	listen, target = spec[:i], spec[i+2:]
+	if strings.HasPrefix(target, ":") {
+		target = "localhost" + target
+	}
+	return
+}
+
+// startProxy listens and passes what arrives to the program, holding each
+// request while a cycle runs or a new build starts, and until the program
+// accepts connections, for at most --proxy-timeout. While the last cycle
+// failed, pages get the failure instead.
+func (s *session) startProxy(spec string) (err error) {
+	listen, target, err := parseProxy(spec)
+	if err != nil {
+		return
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return
+	}
+	dialer := &net.Dialer{Timeout: time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+		if until, ok := ctx.Value(holdUntil{}).(time.Time); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, until)
+			defer cancel()
+		}
+		for {
+			conn, err = dialer.DialContext(ctx, network, addr)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, err
+			}
+		}
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = target
+		},
+		Transport: &holdingTransport{s: s, next: transport},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "rerun: the program did not answer on "+target+": "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	log.Printf("proxying http://%s to the program on %s", ln.Addr(), target)
+	go func() {
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.serveOverlay(w, r) {
+				proxy.ServeHTTP(w, r)
+			}
+		})
+		if err := http.Serve(ln, h); err != nil {
+			log.Printf("proxy: %s", err)
+		}
+	}()
+	return
+}
+
+// holdingTransport holds requests while the program rebuilds, and records
+// them for --replay.
+type holdingTransport struct {
+	s    *session
+	next http.RoundTripper
+}
+
+// holdUntil keys the time a request is held until in its context, which
+// the dialer stops retrying at. Only the hold is limited: the response takes
+// as long as the program does.
+type holdUntil struct{}
+
+func (t *holdingTransport) RoundTrip(r *http.Request) (resp *http.Response, err error) {
+	until := time.Now().Add(*proxy_timeout)
+	timeout := time.NewTimer(*proxy_timeout)
+	defer timeout.Stop()
+	for t.s.rebuilding() {
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout.C:
+			return nil, errors.New("still rebuilding after " + proxy_timeout.String())
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+	resp, err = t.next.RoundTrip(r.WithContext(context.WithValue(r.Context(), holdUntil{}, until)))
+	if err == nil {
+		t.s.replays.record(r)
+	}
+	return
+}
+
+// rebuilding reports whether a cycle is running or a new build of the
+// program is starting.
+func (s *session) rebuilding() bool {
+	return s.queue.status().Building != nil || atomic.LoadInt32(&s.launching) > 0
+}
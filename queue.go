@@ -0,0 +1,176 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// buildQueue holds the batches of changes that arrive while a cycle is
+// running, or while --rate-limit holds them back, merged into one.
+type buildQueue struct {
+	mu       sync.Mutex
+	pending  []watch.Event
+	batches  int
+	since    time.Time
+	building []string
+	started  time.Time
+	last     time.Time
+	every    time.Duration
+	flushed  bool
+	wake     chan bool
+	lane     string
+	cancel   context.CancelFunc
+}
+
+// queueStatus is what the control API reports about the queue.
+type queueStatus struct {
+	Building     []string
+	Lane         string `json:",omitempty"`
+	Started      time.Time
+	Pending      []string
+	Batches      int
+	PendingSince time.Time
+	Next         time.Time
+}
+
+func newBuildQueue(every time.Duration) *buildQueue {
+	return &buildQueue{every: every, wake: make(chan bool, 1)}
+}
+
+// fill queues every batch until batches is closed.
+func (q *buildQueue) fill(batches <-chan []watch.Event) {
+	for batch := range batches {
+		q.mu.Lock()
+		if q.batches == 0 {
+			q.since = time.Now()
+		}
+		before := len(names(q.pending))
+		q.pending = append(q.pending, batch...)
+		q.batches++
+		if laneOf(names(batch)) == lanePriority {
+			q.flushed = true
+			if q.building != nil && q.lane == laneNormal && q.cancel != nil {
+				log.Printf("priority change to %s, cutting the running cycle short", names(batch)[0])
+				q.cancel()
+			}
+		} else if after := len(names(q.pending)); q.building != nil && after > before {
+			log.Printf("%d change(s) queued behind the running cycle", after)
+		}
+		q.mu.Unlock()
+		q.poke()
+	}
+}
+
+func (q *buildQueue) poke() {
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
+
+// next waits until the pending changes may be built, and takes them. The
+// cycle building them runs until cycle is done, which is when ctx is or a
+// priority change arrives.
+func (q *buildQueue) next(ctx context.Context) (batch []watch.Event, cycle context.Context, ok bool) {
+	for {
+		q.mu.Lock()
+		var wait <-chan time.Time
+		if q.pending != nil {
+			rem := q.every - time.Since(q.last)
+			if rem <= 0 || q.flushed {
+				batch = q.pending
+				if q.batches > 1 {
+					log.Printf("coalesced %d batches into one cycle", q.batches)
+				}
+				q.pending, q.batches, q.flushed = nil, 0, false
+				q.building, q.started, q.last = names(batch), time.Now(), time.Now()
+				q.lane = laneOf(names(batch))
+				cycle, q.cancel = context.WithCancel(ctx)
+				q.mu.Unlock()
+				return batch, cycle, true
+			}
+			wait = time.After(rem)
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.wake:
+		case <-wait:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// done marks the running cycle as finished.
+func (q *buildQueue) done() {
+	q.mu.Lock()
+	q.building, q.lane = nil, laneNormal
+	if q.cancel != nil {
+		q.cancel()
+		q.cancel = nil
+	}
+	q.mu.Unlock()
+}
+
+// drop discards the pending changes, and reports how many files they named.
+func (q *buildQueue) drop() (n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n = len(names(q.pending))
+	q.pending, q.batches, q.flushed = nil, 0, false
+	return
+}
+
+// flush lets the pending changes through without waiting for --rate-limit.
+func (q *buildQueue) flush() {
+	q.mu.Lock()
+	q.flushed = true
+	q.mu.Unlock()
+	q.poke()
+}
+
+func (q *buildQueue) status() (st queueStatus) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st = queueStatus{
+		Building:     q.building,
+		Lane:         q.lane,
+		Started:      q.started,
+		Pending:      names(q.pending),
+		Batches:      q.batches,
+		PendingSince: q.since,
+	}
+	if q.pending != nil {
+		st.Next = q.last.Add(q.every)
+		if q.flushed || st.Next.Before(time.Now()) {
+			st.Next = time.Now()
+		}
+		if q.building != nil {
+			// it will wait for the running cycle in any case.
+			st.Next = time.Time{}
+		}
+	}
+	return
+}
+
+// names lists the distinct files in events.
+func names(events []watch.Event) (list []string) {
+	seen := map[string]bool{}
+	for _, ev := range events {
+		if !seen[ev.Name] {
+			seen[ev.Name] = true
+			list = append(list, ev.Name)
+		}
+	}
+	sort.Strings(list)
+	return
+}
@@ -0,0 +1,133 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ready_http    = flag.String("ready-http", "", "After a restart, the program is only ready once GET on this url answers with a 2xx or 3xx status, e.g. http://localhost:8080/healthz")
+	ready_tcp     = flag.String("ready-tcp", "", "After a restart, the program is only ready once it accepts connections on this address, e.g. :8080")
+	ready_regex   regexpFlag
+	ready_timeout = flag.Duration("ready-timeout", 30*time.Second, "How long a restarted program gets to pass the --ready-* probes")
+)
+
+func init() {
+	flag.Var(&ready_regex, "ready-regex", "After a restart, the program is only ready once a line of its output matches this regex, e.g. \"listening on\"")
+}
+
+// probing reports whether any --ready-* probe is set.
+func probing() bool {
+	return *ready_http != "" || *ready_tcp != "" || ready_regex.re != nil
+}
+
+// readyLine notices the program's first line of output matching
+// --ready-regex since the last start.
+type readyLine struct {
+	mu      sync.Mutex
+	matched chan bool
+}
+
+// arm starts looking for the line in the output of a new start. The
+// returned channel is closed once it shows up.
+func (r *readyLine) arm() <-chan bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.matched = make(chan bool)
+	return r.matched
+}
+
+func (r *readyLine) saw(line string) {
+	if ready_regex.re == nil || !ready_regex.re.MatchString(line) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.matched != nil {
+		close(r.matched)
+		r.matched = nil
+	}
+}
+
+// waitReady waits until the program c runs passes every --ready-* probe,
+// for at most --ready-timeout. matched is closed once its output matched
+// --ready-regex.
+func waitReady(c *child, matched <-chan bool) (err error) {
+	deadline := time.After(*ready_timeout)
+	client := &http.Client{Timeout: time.Second}
+	lineOK, tcpOK, httpOK := ready_regex.re == nil, *ready_tcp == "", *ready_http == ""
+	for {
+		if !lineOK {
+			select {
+			case <-matched:
+				lineOK, matched = true, nil
+			default:
+				err = fmt.Errorf("no output matching %q", ready_regex.re)
+			}
+		}
+		if lineOK && !tcpOK {
+			addr := *ready_tcp
+			if strings.HasPrefix(addr, ":") {
+				addr = "localhost" + addr
+			}
+			conn, derr := net.DialTimeout("tcp", addr, time.Second)
+			if derr == nil {
+				conn.Close()
+				tcpOK = true
+			} else {
+				err = derr
+			}
+		}
+		if lineOK && tcpOK && !httpOK {
+			resp, gerr := client.Get(*ready_http)
+			if gerr == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 400 {
+					httpOK = true
+				} else {
+					gerr = fmt.Errorf("GET %s: %s", *ready_http, resp.Status)
+				}
+			}
+			if gerr != nil {
+				err = gerr
+			}
+		}
+		if lineOK && tcpOK && httpOK {
+			return nil
+		}
+		select {
+		case <-c.exited:
+			return errors.New("it exited: " + exitDescription(c.err))
+		case <-deadline:
+			return fmt.Errorf("not ready after %s: %s", *ready_timeout, err)
+		case <-matched:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// probeReady waits for the build c runs for l to pass the --ready-* probes,
+// and reports whether it did.
+func (s *session) probeReady(c *child, l launch, matched <-chan bool) bool {
+	if !probing() {
+		return true
+	}
+	start := time.Now()
+	if err := waitReady(c, matched); err != nil {
+		log.Printf("build %d is not ready: %s", l.build, err)
+		return false
+	}
+	log.Printf("build %d is ready after %s", l.build, time.Since(start).Round(time.Millisecond))
+	return true
+}
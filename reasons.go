@@ -0,0 +1,145 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how many cycles' reasons are kept for the API.
+const keepReasons = 50
+
+// A chain explains how one changed file reached the target.
+type chain struct {
+	File    string   `json:"file"`
+	Package string   `json:"package,omitempty"`
+	Path    []string `json:"path,omitempty"` // imports from the target down to Package
+}
+
+// A cycleReason is the causal chain of one cycle: what changed, why it
+// matters to the target, and what rerun did about it.
+type cycleReason struct {
+	Process string    `json:"process,omitempty"`
+	Cycle   int       `json:"cycle"`
+	Build   int       `json:"build,omitempty"`
+	Lane    string    `json:"lane,omitempty"`
+	Time    time.Time `json:"time"`
+	Target  string    `json:"target"`
+	Chains  []chain   `json:"chains"`
+	Actions []string  `json:"actions"`
+}
+
+// reasonLog keeps the most recent cycle reasons.
+type reasonLog struct {
+	mu      sync.Mutex
+	reasons []*cycleReason
+}
+
+func (l *reasonLog) add(r *cycleReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reasons = append(l.reasons, r)
+	if len(l.reasons) > keepReasons {
+		l.reasons = l.reasons[len(l.reasons)-keepReasons:]
+	}
+}
+
+func (l *reasonLog) recent() []cycleReason {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rs := make([]cycleReason, len(l.reasons))
+	for i, r := range l.reasons {
+		rs[i] = *r
+	}
+	return rs
+}
+
+// explain works out why the changed files affect the target.
+func (s *session) explain(changed []string) *cycleReason {
+	r := &cycleReason{Process: s.name, Cycle: s.cycle, Build: s.buildID, Lane: laneOf(changed), Time: time.Now(), Target: s.buildpath}
+	for _, name := range changed {
+		c := chain{File: name}
+		if importpath, ok := s.packageOf(name); ok {
+			c.Package = importpath
+			c.Path = s.importPath(importpath)
+		}
+		r.Chains = append(r.Chains, c)
+	}
+	return r
+}
+
+// importPath finds a chain of imports leading from the target to importpath.
+func (s *session) importPath(importpath string) []string {
+	prev := map[string]string{s.buildpath: ""}
+	queue := []string{s.buildpath}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == importpath {
+			var p []string
+			for ; cur != ""; cur = prev[cur] {
+				p = append([]string{cur}, p...)
+			}
+			return p
+		}
+		pkg, ok := s.graph[cur]
+		if !ok {
+			continue
+		}
+		for _, imp := range pkg.Imports {
+			if _, seen := prev[imp]; !seen {
+				prev[imp] = cur
+				queue = append(queue, imp)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *cycleReason) act(action string) {
+	r.Actions = append(r.Actions, action)
+}
+
+func (r *cycleReason) String() string {
+	var parts []string
+	for _, c := range r.Chains {
+		switch {
+		case len(c.Path) > 1:
+			parts = append(parts, fmt.Sprintf("%s → %s", c.File, strings.Join(c.Path, " → ")))
+		case c.Package != "":
+			parts = append(parts, fmt.Sprintf("%s → %s", c.File, c.Package))
+		default:
+			parts = append(parts, c.File)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "startup")
+	}
+	lane := ""
+	if r.Lane != laneNormal {
+		lane = ", " + r.Lane + " lane"
+	}
+	process := ""
+	if r.Process != "" {
+		process = r.Process + " "
+	}
+	return fmt.Sprintf("%scycle %d (build %d%s): %s ⇒ %s", process, r.Cycle, r.Build, lane, strings.Join(parts, "; "), strings.Join(r.Actions, ", "))
+}
+
+// step records a pipeline step in the journal and the cycle's reason.
+func (s *session) step(why *cycleReason, name string, start time.Time, result string) {
+	s.journal.step(name, s.cycle, start, result)
+	why.act(name + ": " + result)
+}
+
+// explained logs and keeps the finished reason.
+func (s *session) explained(why *cycleReason) {
+	log.Print(why)
+	s.reasons.add(why)
+}
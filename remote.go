@@ -0,0 +1,71 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+// shellQuote quotes args for a POSIX shell, which is what ssh hands the
+// remote command to.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// remote is the rerun remote command. It runs rerun with the given arguments
+// on another machine over ssh, with its control API, and any ports given
+// with -forward, forwarded to this one. The remote output streams back, and
+// an interrupt shuts the remote rerun down.
+func remote(args []string) (err error) {
+	fs := flag.NewFlagSet("remote", flag.ExitOnError)
+	control := fs.Int("control", 8787, "Port of the remote control API, forwarded to the same port here")
+	rerunCmd := fs.String("rerun", "rerun", "The rerun command on the remote machine")
+	var forward stringList
+	fs.Var(&forward, "forward", "Also forward this port, or local:remote ports, e.g. the program's (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rerun remote [-control port] [-forward port]* [-rerun cmd] <[user@]host> [--] [rerun flags] <import path> [arg]*")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	host, rest := fs.Arg(0), fs.Args()[1:]
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+
+	sshArgs := []string{"-tt", "-o", "ExitOnForwardFailure=yes",
+		"-L", fmt.Sprintf("%d:localhost:%d", *control, *control)}
+	for _, port := range forward {
+		local, remote := port, port
+		if i := strings.Index(port, ":"); i >= 0 {
+			local, remote = port[:i], port[i+1:]
+		}
+		sshArgs = append(sshArgs, "-L", local+":localhost:"+remote)
+	}
+	remoteArgs := append([]string{*rerunCmd, "--http-control", fmt.Sprintf("localhost:%d", *control)}, rest...)
+	sshArgs = append(sshArgs, host, shellQuote(remoteArgs))
+
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// the interrupt is for the remote rerun, which gets it through ssh.
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	log.Printf("running rerun on %s; its control API is at http://localhost:%d (rerun attach --observe localhost:%d)", host, *control, *control)
+	return cmd.Run()
+}
@@ -6,16 +6,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/howeyc/fsnotify"
+	"github.com/skelterjohn/rerun/watch"
 	"go/build"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 var (
@@ -23,23 +33,133 @@ var (
 	do_build      = flag.Bool("build", false, "Build program")
 	never_run     = flag.Bool("no-run", false, "Do not run")
 	race_detector = flag.Bool("race", false, "Run program and tests with the race detector")
+	trace_build   = flag.Bool("trace-build", false, "Run go commands with -x -work and save their output for each cycle")
+	size_warn     = flag.Int64("size-warn", 0, "Warn when the binary grows by more than this many bytes in one build")
+	do_escape     = flag.Bool("escape", false, "Report changes in escape analysis and inlining decisions of changed packages")
+	debounce      = flag.Duration("debounce", 100*time.Millisecond, "How long changes must be quiet before rebuilding")
+	debounce_for  windowList
+	use_journal   = flag.Bool("journal", false, "Append JSON lifecycle records to a per-project journal file")
+	daemon_addr   = flag.String("daemon", "", "Get file system events from the shared watcher daemon at this address")
+	editor_stdio  = flag.Bool("editor", false, "Read save notifications from an editor plugin on stdin")
+	fifo_path     = flag.String("fifo", "", "Read trigger lines from this named pipe")
+	idle_after    = flag.Duration("idle-after", 0, "Enter a low-power idle mode after this long without changes (0 never)")
+	rate_limit    = flag.Duration("rate-limit", 0, "Rebuild at most once per this duration, coalescing changes in between")
+	burst_size    = flag.Int("burst-size", 100, "Treat this many changes in one batch as a burst, such as a generator rewriting files, and rebuild once it is over (0 off)")
+	burst_rate    = flag.Int("burst-rate", 200, "Treat this many changes within a second as a burst (0 off)")
+	burst_quiet   = flag.Duration("burst-quiet", 500*time.Millisecond, "A burst is over after this long without changes")
+	prebuilt      = flag.Bool("prebuilt", false, "Supervise an existing binary or script, given instead of an import path, without building it")
+	watch_paths   stringList
 )
 
-func install(buildpath, lastError string) (installed bool, errorOutput string, err error) {
-	cmdline := []string{"go", "get"}
+func init() {
+	flag.Var(&watch_paths, "watch", "Also watch this file or directory (repeatable)")
+	flag.Var(&debounce_for, "debounce-for", "Use a different debounce interval for matching files, as pattern=duration (repeatable)")
+}
+
+// traceArgs returns the extra go command arguments used with --trace-build.
+func traceArgs() []string {
+	if !*trace_build {
+		return nil
+	}
+	return []string{"-x", "-work"}
+}
+
+// saveTrace writes the output of a traced go command to a per-cycle file and
+// reports which packages the toolchain actually recompiled.
+func saveTrace(buildpath string, cycle int, step string, output []byte) {
+	dir := filepath.Join(os.TempDir(), "rerun-trace", projectKey(buildpath))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Printf("error creating trace directory: %s", err)
+		return
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%04d-%s.log", cycle, step))
+	err = ioutil.WriteFile(name, output, 0644)
+	if err != nil {
+		log.Printf("error writing trace: %s", err)
+		return
+	}
+	pkgs := recompiled(output)
+	log.Printf("%s trace written to %s", step, name)
+	log.Printf("%s recompiled %d packages %v", step, len(pkgs), pkgs)
+}
+
+// recompiled finds the packages that were handed to the compiler in the
+// output of a go command run with -x.
+func recompiled(output []byte) (pkgs []string) {
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || path.Base(fields[0]) != "compile" {
+			continue
+		}
+		for i := 1; i < len(fields)-1; i++ {
+			if fields[i] == "-p" {
+				pkgs = append(pkgs, fields[i+1])
+				break
+			}
+		}
+	}
+	return
+}
+
+// installEnv is the environment the program is built in.
+func installEnv() []string {
+	env := os.Environ()
+	if *container_image != "" {
+		// the container's userland may not have our libc.
+		env = append(env, "CGO_ENABLED=0")
+		if runtime.GOOS != "linux" {
+			// containers run linux, on the host's architecture.
+			env = append(env, "GOOS=linux", "GOARCH="+runtime.GOARCH)
+		}
+	}
+	if *no_install {
+		// whatever the module cache lacks is an error, not a download.
+		env = append(env, "GOPROXY=off")
+	}
+	return toolchainEnv(throttled(env))
+}
+
+func install(buildpath, binPath, lastError string, cycle, build int) (installed bool, errorOutput string, err error) {
+	cmdline := []string{"go", "build", "-o", binPath}
 
 	if *race_detector {
 		cmdline = append(cmdline, "-race")
 	}
+	cmdline = append(cmdline, traceArgs()...)
+	cmdline = append(cmdline, buildFlags(build)...)
 	cmdline = append(cmdline, buildpath)
 
 	// setup the build command, use a shared buffer for both stdOut and stdErr
-	cmd := exec.Command("go", cmdline[1:]...)
+	cmd := command("go", cmdline[1:]...)
+	cmd.Env = installEnv()
 	buf := bytes.NewBuffer([]byte{})
 	cmd.Stdout = buf
 	cmd.Stderr = buf
 
 	err = cmd.Run()
+	// another session or an IDE may be writing or running the same binary;
+	// give it a moment.
+	backoff := installBackoff
+	for i := 0; i < installRetries && err != nil && busy(buf.String()); i++ {
+		log.Printf("the binary is busy, retrying in %s", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		buf.Reset()
+		cmd = command("go", cmdline[1:]...)
+		cmd.Env = installEnv()
+		cmd.Stdout = buf
+		cmd.Stderr = buf
+		err = cmd.Run()
+	}
+
+	// with tracing on there is always output, so only the exit status counts.
+	if *trace_build {
+		saveTrace(buildpath, cycle, "install", buf.Bytes())
+		if err == nil {
+			buf.Reset()
+		}
+	}
 
 	// when there is any output, the go command failed.
 	if buf.Len() > 0 {
@@ -56,22 +176,77 @@ func install(buildpath, lastError string) (installed bool, errorOutput string, e
 	return
 }
 
-func test(buildpath string) (passed bool, err error) {
+// reportSize logs the size of the freshly installed binary and how it changed
+// since the last build.
+func reportSize(binPath string, lastSize int64) (size int64) {
+	fi, err := os.Stat(binPath)
+	if err != nil {
+		return lastSize
+	}
+	size = fi.Size()
+	if lastSize == 0 {
+		log.Printf("binary size %d bytes", size)
+		return
+	}
+	delta := size - lastSize
+	log.Printf("binary size %d bytes (%+d)", size, delta)
+	if *size_warn > 0 && delta > *size_warn {
+		log.Printf("warning: binary grew by %d bytes, more than --size-warn=%d", delta, *size_warn)
+	}
+	return
+}
+
+func test(ctx context.Context, buildpath string, cycle int, run string) (passed bool, output string, err error) {
+	if len(test_workers) > 0 && run == "" {
+		// focused runs and retries are small enough to run here.
+		return testSharded(ctx, buildpath, cycle)
+	}
 	cmdline := []string{"go", "test"}
 
 	if *race_detector {
 		cmdline = append(cmdline, "-race")
 	}
-	cmdline = append(cmdline, "-v", buildpath)
+	cmdline = append(cmdline, traceArgs()...)
+	cmdline = append(cmdline, buildFlags(0)...)
+	// --focus and retries narrow down whatever -run the test flags give.
+	cmdline = append(cmdline, test_flags...)
+	if run != "" {
+		cmdline = append(cmdline, "-run", run)
+	}
 
 	// setup the build command, use a shared buffer for both stdOut and stdErr
-	cmd := exec.Command("go", cmdline[1:]...)
+	cmd := command("go")
+	cmd.Env = installEnv()
+	target := buildpath
+	var sb *sandbox
+	if *test_sandbox {
+		var serr error
+		if sb, serr = newSandbox(buildpath); serr != nil {
+			log.Printf("cannot copy the sources for the tests, running them in place: %s", serr)
+		} else {
+			defer sb.remove()
+			target = sb.setup(cmd, buildpath)
+		}
+	}
+	cmd.Args = append(cmd.Args, append(cmdline[1:], "-v", target)...)
 	buf := bytes.NewBuffer([]byte{})
 	cmd.Stdout = buf
 	cmd.Stderr = buf
 
-	err = cmd.Run()
+	err = runCycleCmd(ctx, cmd)
+	if ctx.Err() != nil {
+		// cut short, the output means nothing.
+		return
+	}
 	passed = err == nil
+	if sb != nil {
+		buf = bytes.NewBuffer(sb.mapBack(buf.Bytes()))
+	}
+	output = buf.String()
+
+	if *trace_build {
+		saveTrace(buildpath, cycle, "test", buf.Bytes())
+	}
 
 	if !passed {
 		fmt.Println(buf)
@@ -82,22 +257,33 @@ func test(buildpath string) (passed bool, err error) {
 	return
 }
 
-func gobuild(buildpath string) (passed bool, err error) {
+func gobuild(ctx context.Context, buildpath string, cycle int) (passed bool, output string, err error) {
 	cmdline := []string{"go", "build"}
 
 	if *race_detector {
 		cmdline = append(cmdline, "-race")
 	}
+	cmdline = append(cmdline, traceArgs()...)
+	cmdline = append(cmdline, buildFlags(0)...)
 	cmdline = append(cmdline, "-v", buildpath)
 
 	// setup the build command, use a shared buffer for both stdOut and stdErr
-	cmd := exec.Command("go", cmdline[1:]...)
+	cmd := command("go", cmdline[1:]...)
+	cmd.Env = installEnv()
 	buf := bytes.NewBuffer([]byte{})
 	cmd.Stdout = buf
 	cmd.Stderr = buf
 
-	err = cmd.Run()
+	err = runCycleCmd(ctx, cmd)
+	if ctx.Err() != nil {
+		return
+	}
 	passed = err == nil
+	output = buf.String()
+
+	if *trace_build {
+		saveTrace(buildpath, cycle, "build", buf.Bytes())
+	}
 
 	if !passed {
 		fmt.Println(buf)
@@ -108,180 +294,1142 @@ func gobuild(buildpath string) (passed bool, err error) {
 	return
 }
 
-func run(binName, binPath string, args []string) (runch chan bool) {
-	runch = make(chan bool)
+var (
+	// the signal that asks the program to exit, and how long it gets to do
+	// so before it is killed.
+	stop_signal  = signalFlag{"INT", os.Interrupt}
+	kill_timeout = flag.Duration("kill-timeout", 5*time.Second, "How long the program gets to exit after --signal before it is killed")
+)
+
+func init() {
+	flag.Var(&stop_signal, "signal", "Signal that asks the program to exit: INT, TERM, HUP, QUIT, USR1, USR2 or KILL")
+}
+
+// children holds every process rerun has started and not yet seen exit, so
+// that a forced quit can take them down.
+var children = struct {
+	sync.Mutex
+	procs map[*os.Process]bool
+}{procs: map[*os.Process]bool{}}
+
+func track(proc *os.Process) {
+	children.Lock()
+	children.procs[proc] = true
+	children.Unlock()
+}
+
+func untrack(proc *os.Process) {
+	children.Lock()
+	delete(children.procs, proc)
+	children.Unlock()
+}
+
+// killChildren kills every tracked process without waiting.
+func killChildren() {
+	children.Lock()
+	defer children.Unlock()
+	for proc := range children.procs {
+		log.Printf("killing process %d", proc.Pid)
+		killGroup(proc)
+	}
+}
+
+// a child is a running program.
+type child struct {
+	proc    *os.Process
+	started time.Time
+	exited  chan bool // closed once it exited
+	err     error     // how it exited
+}
+
+// startChild starts cmd in a process group of its own and waits for it in
+// the background.
+func startChild(cmd *exec.Cmd) (c *child, err error) {
+	ownGroup(cmd)
+	// processes it started may hold on to its output; don't wait for them.
+	cmd.WaitDelay = time.Second
+	err = cmd.Start()
+	if err != nil {
+		return
+	}
+	c = &child{proc: cmd.Process, started: time.Now(), exited: make(chan bool)}
+	joinGroup(c.proc)
+	track(c.proc)
+	go func() {
+		c.err = cmd.Wait()
+		untrack(c.proc)
+		close(c.exited)
+	}()
+	return
+}
+
+// stop asks c and the processes it started to exit with --signal and kills
+// them if c has not exited within --kill-timeout.
+func stop(c *child) {
+	proc := c.proc
+	err := signalGroup(proc, stop_signal.sig)
+	if err != nil {
+		log.Printf("error on sending signal to process: '%s', will now hard-kill the process\n", err)
+		killGroup(proc)
+	}
+	select {
+	case <-c.exited:
+	case <-time.After(*kill_timeout):
+		log.Printf("process did not exit within %s, killing it", *kill_timeout)
+		killGroup(proc)
+		<-c.exited
+	}
+	// whatever it started and left behind would hold on to its ports.
+	killGroup(proc)
+	leaveGroup(proc)
+}
+
+// a launch tells the run goroutine to restart the program from a binary with
+// the given hash, built in the given cycle, or, if !relaunch, only to stop it.
+type launch struct {
+	relaunch bool
+	hash     string
+	cycle    int
+	build    int
+	saved    time.Time
+	trigger  []string
+}
+
+// run starts a goroutine that (re)launches the program for each launch sent
+// on runch. Right before starting it, the binary is checked against the hash
+// it was built with; if something else overwrote it in the meantime it is not
+// run and a rebuild is requested instead. A restart is bracketed by the
+// --snapshot and --restore hooks. With --restart-on-exit, a program that
+// fails is started again. Once runch is closed the program is stopped for
+// good and done is closed.
+func (s *session) run() (runch chan launch, done chan bool) {
+	runch = make(chan launch)
+	done = make(chan bool)
 	go func() {
-		cmdline := append([]string{binName}, args...)
-		var proc *os.Process
-		for relaunch := range runch {
-			if proc != nil {
-				err := proc.Signal(os.Interrupt)
-				if err != nil {
-					log.Printf("error on sending signal to process: '%s', will now hard-kill the process\n", err)
-					proc.Kill()
+		defer close(done)
+		var c *child
+		var cbuild int // the build c runs
+		var last launch
+		var exited <-chan bool
+		var retry <-chan time.Time
+		var crashes int
+		var lastEnv []string
+		// owed is set while a launch from the loop is being handled.
+		var owed bool
+		// why is why the next start happens.
+		var why string
+		for {
+			if owed {
+				owed = false
+				s.launchDone()
+			}
+			var l launch
+			select {
+			case next, ok := <-runch:
+				if !ok {
+					if c != nil {
+						stop(c)
+						s.procStopped(c, cbuild, "shutdown")
+						s.childStopped()
+					}
+					return
+				}
+				l, retry, crashes = next, nil, 0
+				owed = l.relaunch
+				why = fmt.Sprintf("cycle %d", l.cycle)
+			case <-exited:
+				exited = nil
+				log.Printf("%s exited: %s", s.binName, exitDescription(c.err))
+				s.procStopped(c, cbuild, "exited")
+				if *exit_on_child_exit {
+					childStatus = childExitCode(c.err)
+					c = nil
+					s.childStopped()
+					s.quit()
+					continue
+				}
+				if c.err == nil || !*restart_on_exit {
+					c = nil
+					s.childStopped()
+					// the next cycle has to start it, changed or not.
+					atomic.StoreInt32(&s.halted, 1)
+					continue
+				}
+				if time.Since(c.started) > stableAfter {
+					crashes = 0
+				}
+				c = nil
+				s.childStopped()
+				retry = s.crashed(crashes)
+				if retry == nil {
+					atomic.StoreInt32(&s.halted, 1)
+				}
+				crashes++
+				continue
+			case <-retry:
+				retry = nil
+				l = last
+				l.saved = time.Time{}
+				why = fmt.Sprintf("restart %d after it exited", crashes)
+			}
+			var scratch string
+			// a standby, or the old build with --handoff, keeps serving
+			// until the new build is ready to take over.
+			if c != nil && !(l.relaunch && (s.standby != nil || s.handingOff())) {
+				reason := "stop requested"
+				if l.relaunch {
+					scratch = s.snapshot()
+					reason = fmt.Sprintf("replaced by build %d", l.build)
 				}
-				proc.Wait()
+				stop(c)
+				s.procStopped(c, cbuild, reason)
+				c, exited = nil, nil
+				s.childStopped()
+			}
+			if !l.relaunch {
+				continue
 			}
-			if !relaunch {
+			last = l
+			binPath := s.binPath
+			if sum, err := hashFile(binPath); l.hash != "" && sum != l.hash {
+				if err == nil {
+					err = errors.New("its checksum changed")
+				}
+				log.Printf("%s was modified after rerun built it (%s), not running it", binPath, err)
+				s.tampered()
+				os.RemoveAll(scratch)
 				continue
 			}
-			cmd := exec.Command(binPath, args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			log.Print(cmdline)
-			err := cmd.Start()
+			cmd := s.command()
+			if cmd.Env == nil {
+				cmd.Env = os.Environ()
+			}
+			if *container_image == "" {
+				// the container runtime itself may need them; the container
+				// gets what containerCredentials forwards.
+				cmd.Env = withoutCredentials(cmd.Env)
+			}
+			cmd.Env = append(cmd.Env, s.childEnv()...)
+			cmd.Env = append(cmd.Env, buildIDEnv(l.build)...)
+			if lastEnv != nil {
+				if diff := envDiff(lastEnv, cmd.Env); len(diff) > 0 {
+					log.Printf("the program's environment changed:\n\t%s", strings.Join(diff, "\n\t"))
+				}
+			}
+			lastEnv = cmd.Env
+			var target string
+			if s.standby != nil {
+				port, err := s.standby.freePort()
+				if err != nil {
+					log.Printf("error finding a port for the program: %s", err)
+					continue
+				}
+				target = "127.0.0.1:" + port
+				cmd.Env = append(cmd.Env, "PORT="+port)
+			}
+			s.activate(cmd)
+			matched := s.readyLine.arm()
+			cmd.Stdout = s.output(os.Stdout, "stdout", l)
+			cmd.Stderr = s.output(os.Stderr, "stderr", l)
+			log.Print(cmd.Args)
+			nc, err := startChild(cmd)
 			if err != nil {
 				log.Printf("error on starting process: '%s'\n", err)
+				os.RemoveAll(scratch)
+				continue
+			}
+			s.procStarted(nc, l, why)
+			if s.standby != nil {
+				if err = s.standby.healthy(nc, target); err != nil {
+					if c != nil {
+						log.Printf("build %d failed its health check, build %d keeps serving: %s", l.build, s.standby.build, err)
+					} else {
+						log.Printf("build %d failed its health check: %s", l.build, err)
+					}
+					reason := "failed its health check"
+					if abandon(nc) {
+						reason = "exited before it was healthy"
+					}
+					s.procStopped(nc, l.build, reason)
+					continue
+				}
+				waitLockstep(l.build)
+				s.standby.promote(target, l.build)
+				log.Printf("build %d is healthy, serving it", l.build)
+				if c != nil {
+					stop(c)
+					s.procStopped(c, cbuild, fmt.Sprintf("replaced by build %d", l.build))
+					s.childStopped()
+				}
+			}
+			if s.handingOff() && c != nil {
+				if err = takeOver(nc, matched); err != nil {
+					log.Printf("build %d did not take over, build %d keeps serving: %s", l.build, cbuild, err)
+					reason := "did not take over"
+					if abandon(nc) {
+						reason = "exited before it took over"
+					}
+					s.procStopped(nc, l.build, reason)
+					continue
+				}
+				log.Printf("build %d took over, stopping build %d", l.build, cbuild)
+				stop(c)
+				s.procStopped(c, cbuild, fmt.Sprintf("replaced by build %d", l.build))
+				s.childStopped()
+			}
+			c, cbuild = nc, l.build
+			exited = c.exited
+			go s.usage.watch(s.binName, c.proc.Pid, l.build, c.exited)
+			s.written.childStarted()
+			s.restore(scratch)
+			if probing() || *warmup_request != "" {
+				// the program is ready once it passes the probes and
+				// answers.
+				go func(c *child, l launch, owed bool) {
+					if s.probeReady(c, l, matched) && (*warmup_request == "" || warmUp()) {
+						s.ready(l, c.proc.Pid)
+					}
+					if owed {
+						s.launchDone()
+					}
+				}(c, l, owed)
+				owed = false
+			} else {
+				s.ready(l, c.proc.Pid)
 			}
-			proc = cmd.Process
 		}
 	}()
 	return
 }
 
-func getWatcher(buildpath string) (watcher *fsnotify.Watcher, err error) {
-	watcher, err = fsnotify.NewWatcher()
-	addToWatcher(watcher, buildpath, map[string]bool{})
+// command sets up the program to run, directly, under delve or --wrap, in
+// a container or in an emulator, or the --exec command.
+func (s *session) command() *exec.Cmd {
+	if *exec_cmd != "" {
+		return shellCommand(*exec_cmd)
+	}
+	if *container_image != "" {
+		return s.containerCommand()
+	}
+	if *emulator != "" {
+		return s.emulatorCommand()
+	}
+	if *debug_build {
+		return s.debugCommand()
+	}
+	if len(wrap_cmd) > 0 {
+		return s.wrappedCommand()
+	}
+	return command(s.binPath, s.args...)
+}
+
+// childEnv is added to the program's environment.
+func (s *session) childEnv() (env []string) {
+	env = append(env, s.assetEnv()...)
+	env = append(env, envFileEnv()...)
 	return
 }
 
-func addToWatcher(watcher *fsnotify.Watcher, importpath string, watching map[string]bool) {
-	pkg, err := build.Import(importpath, "", 0)
-	if err != nil {
+// childStopped cleans up after the program was stopped.
+func (s *session) childStopped() {
+	if *container_image != "" {
+		s.removeContainer()
+	}
+}
+
+// A session watches, rebuilds and reruns one main package.
+type session struct {
+	name      string // of the --proc, if there are several
+	buildpath string
+	args      []string
+	binName   string
+	binPath   string
+	dir       string
+
+	runch   chan launch
+	stopped chan bool
+	events  chan watch.Event
+	reasons reasonLog
+	procs   procLog
+	journal *journal
+
+	cycle       int
+	errorOutput string
+	binSize     int64
+	runningHash string
+	runningBin  string // with --build-id-var, a copy of the running binary
+	runningID   int    // and its build ID
+	escapes     escapeReports
+	graph       depGraph
+	failing     []string
+	failures    int
+	queue       *buildQueue
+	written     *selfWrites
+	last        *cycleRecord
+	private     bool
+	summary     *sessionSummary
+	builtWith   string
+	sockets     []*os.File
+	flakes      *flakes
+	watching    map[string]bool
+	saved       time.Time
+	latency     latencies
+	buildID     int
+	quit        context.CancelFunc
+	before      beforeWindow
+	standby     *standby
+	followers   *followers
+	overlay     overlay
+	replays     replayRing
+	status      atomic.Value // loopStatus, see publishStatus
+	readyLine   readyLine
+	usage       usageMeter
+	testOnce    int32 // set to run the tests in the next cycle
+	halted      int32 // set once the program was stopped on request or exited for good
+	launching   int32 // launches sent to run and not yet handled
+	broken      int32 // set while the last cycle failed
+	runMu       sync.Mutex
+}
+
+func newSession(name, buildpath string, args []string) (s *session, err error) {
+	s = &session{
+		name:      name,
+		buildpath: buildpath,
+		args:      args,
+		escapes:   escapeReports{},
+		graph:     depGraph{},
+		events:    make(chan watch.Event),
+		queue:     newBuildQueue(*rate_limit),
+		written:   newSelfWrites(),
+		summary:   newSummary(buildpath),
+		flakes:    newFlakes(),
+	}
+	if len(wrap_cmd) > 0 && (*exec_cmd != "" || *container_image != "" || *emulator != "") {
+		return nil, errors.New("--wrap does not work with --exec, --containerize or --emulator, which start the program themselves")
+	}
+	if *debug_build {
+		if err = checkDebug(); err != nil {
+			return
+		}
+	}
+	if *no_install && noBuild() {
+		return nil, errors.New("--no-install is about building the program, it does not work with --prebuilt or --exec")
+	}
+	if err = s.locate(); err != nil {
 		return
 	}
-	if pkg.Goroot {
+	if !noBuild() {
+		pinToolchain(s.dir)
+	}
+	if _, serr := os.Stat(s.binPath); os.IsNotExist(serr) && !noBuild() {
+		// the binary only exists because of this session.
+		created.add(s.binPath)
+	}
+	s.sockets, err = openSockets()
+	if err != nil {
 		return
 	}
-	watcher.Watch(pkg.Dir)
-	watching[importpath] = true
-	for _, imp := range pkg.Imports {
-		if !watching[imp] {
-			addToWatcher(watcher, imp, watching)
+	if *standby_addr != "" {
+		if *container_image != "" {
+			return nil, errors.New("--standby does not work with --containerize")
+		}
+		s.standby, err = startStandby(*standby_addr)
+		if err != nil {
+			return
+		}
+	}
+	if *handoff {
+		if len(s.sockets) == 0 {
+			return nil, errors.New("--handoff needs the program's sockets from --listen")
+		}
+		if *standby_addr != "" {
+			return nil, errors.New("--handoff does not work with --standby")
+		}
+	}
+	if *replay_count > 0 && *proxy_spec == "" {
+		return nil, errors.New("--replay replays what went through --proxy, it needs --proxy")
+	}
+	if *proxy_spec != "" {
+		if *standby_addr != "" {
+			return nil, errors.New("--proxy does not work with --standby, which serves the program itself")
+		}
+		if err = s.startProxy(*proxy_spec); err != nil {
+			return
+		}
+	}
+	if *lead_addr != "" {
+		if s.followers, err = lead(*lead_addr); err != nil {
+			return
 		}
 	}
+	if *use_journal {
+		s.journal, err = openJournal(buildpath)
+	}
+	return
 }
 
-func rerun(buildpath string, args []string) (err error) {
-	log.Printf("setting up %s %v", buildpath, args)
+// locate finds the program's directory, name and binary.
+func (s *session) locate() (err error) {
+	buildpath := s.buildpath
+	if *exec_cmd != "" {
+		// a shell command stands in for the program.
+		s.binName = strings.Fields(*exec_cmd)[0]
+		s.dir, err = os.Getwd()
+	} else if *prebuilt {
+		// buildpath names an existing program, nothing is built.
+		s.binPath, err = exec.LookPath(buildpath)
+		if err != nil {
+			return
+		}
+		s.binPath, err = filepath.Abs(s.binPath)
+		if err != nil {
+			return
+		}
+		s.binName = filepath.Base(s.binPath)
+		s.dir, err = os.Getwd()
+	} else {
+		// finding the package already takes the go command.
+		if _, err = checkToolchain("."); err != nil {
+			return
+		}
+		var pkg *build.Package
+		pkg, err = build.Import(buildpath, "", 0)
+		if err != nil {
+			return
+		}
 
-	pkg, err := build.Import(buildpath, "", 0)
-	if err != nil {
-		return
+		if pkg.Name != "main" {
+			err = errors.New(fmt.Sprintf("expected package %q, got %q", "main", pkg.Name))
+			return
+		}
+
+		s.dir = pkg.Dir
+		_, s.binName = path.Split(buildpath)
+		if s.name != "" {
+			s.binName = s.name
+		}
+		if *no_install {
+			s.binPath, err = throwawayPath(s.binName)
+		} else {
+			s.binPath, err = binaryPath(s.binName, pkg.Dir)
+		}
 	}
+	return
+}
 
-	if pkg.Name != "main" {
-		err = errors.New(fmt.Sprintf("expected package %q, got %q", "main", pkg.Name))
+// rebuild runs one cycle for the given changed files: install, test, build
+// and finally restart the program.
+func (s *session) rebuild(ctx context.Context, changed []string) {
+	s.cycle++
+	trigger := changed
+	if trigger == nil {
+		trigger = []string{"startup"}
+	}
+	rec := beginCycle(s.cycle, trigger)
+	s.buildID = nextBuildID(s.buildpath)
+	rec.Build = s.buildID
+	s.last = rec
+	s.latency.building(s.saved, rec.Start)
+	s.journal.record(journalEntry{Event: "change", Cycle: s.cycle, Files: trigger})
+	why := s.explain(changed)
+	defer s.explained(why)
+	defer s.saveState(rec)
+	defer s.summary.add(rec)
+
+	if *assets_dir != "" && labels.stageOn("assets") && (changed == nil || anyAsset(changed)) {
+		start := time.Now()
+		err := s.fingerprintAssets()
+		if err != nil {
+			log.Printf("error fingerprinting assets: %s", err)
+			s.step(why, "assets", start, "failed")
+		} else {
+			s.step(why, "assets", start, "ok")
+			// the program has to be restarted to see the new manifest.
+			s.runningHash = ""
+		}
+	}
+
+	if !s.regenerate(rec, why, changed) {
+		return
+	}
+	if !s.runRules(rec, why, changed) {
+		return
+	}
+	if !s.runBefore(rec, why) {
 		return
 	}
 
-	_, binName := path.Split(buildpath)
-	var binPath string
-	if gobin := os.Getenv("GOBIN"); gobin != "" {
-		binPath = filepath.Join(gobin, binName)
+	if noBuild() {
+		// whatever changed, the program has to pick it up.
+		rec.Binary, _ = hashFile(s.binPath)
+		rec.finish(s.buildpath, "ok", "")
+		s.runningHash = ""
 	} else {
-		binPath = filepath.Join(pkg.BinDir, binName)
+		ok := s.build(ctx, rec, why, changed)
+		s.afterBuild(rec)
+		if !ok {
+			return
+		}
 	}
 
-	var runch chan bool
+	if atomic.SwapInt32(&s.halted, 0) == 1 {
+		// nothing is running.
+		s.runningHash = ""
+	}
+	// go builds are reproducible, so a rebuild that only touched comments
+	// hashes the same as the build that is already running.
+	if s.runningHash != "" && s.sameCode(rec) {
+		log.Println("no functional change, not restarting")
+		s.journal.record(journalEntry{Event: "unchanged", Cycle: s.cycle})
+		why.act("no restart: binary unchanged")
+		return
+	}
+
+	// rerun. if we're only testing, sending
 	if !(*never_run) {
-		runch = run(binName, binPath, args)
+		s.keepRunning(rec)
+		s.journal.record(journalEntry{Event: "restart", Cycle: s.cycle})
+		why.act("restart")
+		s.summary.Restarts++
+		atomic.AddInt32(&s.launching, 1)
+		s.runch <- launch{relaunch: true, hash: rec.Binary, cycle: s.cycle, build: s.buildID, saved: s.saved, trigger: rec.Trigger}
 	}
+}
 
-	no_run := false
-	if *do_tests {
-		passed, _ := test(buildpath)
-		if !passed {
-			no_run = true
+// anyAsset reports whether any of names lies in the asset tree.
+func anyAsset(names []string) bool {
+	for _, name := range names {
+		if isAsset(name) {
+			return true
 		}
 	}
+	return false
+}
 
-	if *do_build && !no_run {
-		gobuild(buildpath)
+// build runs the go toolchain steps of a cycle, and reports whether the
+// program should be restarted.
+func (s *session) build(ctx context.Context, rec *cycleRecord, why *cycleReason, changed []string) (ok bool) {
+	start := time.Now()
+	var err error
+	rec.Go, err = checkToolchain(s.dir)
+	if err != nil {
+		// the toolchain broke or went away mid-session; the program keeps
+		// running until it is fixed.
+		if err.Error() != s.errorOutput {
+			log.Print(err)
+		}
+		s.errorOutput = err.Error()
+		s.step(why, "toolchain", start, "toolchain error")
+		rec.finish(s.buildpath, "toolchain error", s.errorOutput)
+		return
+	}
+	if rec.Go != s.builtWith {
+		log.Printf("building with %s", rec.Go)
+		s.builtWith = rec.Go
 	}
 
+	start = time.Now()
+	var installed bool
 	var errorOutput string
-	_, errorOutput, ierr := install(buildpath, errorOutput)
-	if !no_run && !(*never_run) && ierr == nil {
-		runch <- true
+	for attempt := 0; ; attempt++ {
+		if s.private {
+			installed, errorOutput = s.installPrivate()
+		} else {
+			installed, errorOutput, _ = install(s.buildpath, s.binPath, s.errorOutput, s.cycle, s.buildID)
+			if !installed && busy(errorOutput) {
+				log.Printf("%s stays busy, building a private binary instead", s.binPath)
+				installed, errorOutput = s.installPrivate()
+			}
+		}
+		s.errorOutput = errorOutput
+		reason := transient(errorOutput)
+		if installed || reason == "" {
+			break
+		}
+		if attempt >= *transient_retries {
+			if attempt > 0 {
+				log.Printf("the build still fails (%s) after %d retries, giving up", reason, attempt)
+			}
+			break
+		}
+		wait := *transient_backoff << uint(attempt)
+		log.Printf("the build failed for a passing reason (%s), retrying in %s", reason, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		if s.preempted(ctx, rec, why) {
+			return
+		}
 	}
-
-	var watcher *fsnotify.Watcher
-	watcher, err = getWatcher(buildpath)
-	if err != nil {
+	if !installed {
+		s.step(why, "install", start, "compile error")
+		rec.finish(s.buildpath, "compile error", errorOutput)
 		return
 	}
+	s.step(why, "install", start, "ok")
+	rec.Binary, _ = hashFile(s.binPath)
+	s.binSize = reportSize(s.binPath, s.binSize)
+	rec.Size = s.binSize
+	if *do_escape && labels.stageOn("escape") {
+		var pkgs []string
+		seen := map[string]bool{}
+		for _, name := range changed {
+			if importpath, ok := s.packageOf(name); ok && !seen[importpath] {
+				seen[importpath] = true
+				pkgs = append(pkgs, importpath)
+			}
+		}
+		if changed == nil {
+			pkgs = []string{s.buildpath}
+		}
+		s.escapes.update(pkgs)
+		why.act("escape analysis")
+	}
 
-	for {
-		// read event from the watcher
-		we, _ := <-watcher.Event
-		// other files in the directory don't count - we watch the whole thing in case new .go files appear.
-		if filepath.Ext(we.Name) != ".go" {
-			continue
+	if s.preempted(ctx, rec, why) {
+		return
+	}
+	if (atomic.SwapInt32(&s.testOnce, 0) == 1 || *do_tests) && labels.stageOn("test") {
+		start = time.Now()
+		passed, output, _ := test(ctx, s.buildpath, s.cycle, focus.get())
+		if s.preempted(ctx, rec, why) {
+			return
+		}
+		s.failing = failingTests(output)
+		if flipped := s.flakes.record(s.testCode(rec.Binary), testResults(output)); len(flipped) > 0 {
+			log.Printf("%s changed outcome with no related change, suspected flaky", strings.Join(flipped, ", "))
+		}
+		if !passed && *retry_flaky > 0 {
+			if passed, _ = s.retryTests(ctx, s.failing); passed {
+				s.failing = nil
+			}
+		}
+		if s.preempted(ctx, rec, why) {
+			return
 		}
+		if !passed {
+			s.step(why, "test", start, "test failure")
+			rec.finish(s.buildpath, "test failure", output)
+			return
+		}
+		s.step(why, "test", start, "ok")
+	}
 
-		log.Print(we.Name)
+	if *do_build && labels.stageOn("build") {
+		start = time.Now()
+		passed, output, _ := gobuild(ctx, s.buildpath, s.cycle)
+		if s.preempted(ctx, rec, why) {
+			return
+		}
+		if !passed {
+			s.step(why, "build", start, "build failure")
+			rec.finish(s.buildpath, "build failure", output)
+		} else {
+			s.step(why, "build", start, "ok")
+		}
+	}
+	if *bench_base != "" && labels.stageOn("bench") && rec.Result == "" && !s.preempted(ctx, rec, why) {
+		start = time.Now()
+		err := benchCompare(s.buildpath, *bench_base)
+		if err != nil {
+			log.Printf("benchmarks: %s", err)
+			s.step(why, "bench", start, "failed")
+		} else {
+			s.step(why, "bench", start, "ok")
+		}
+	}
+	if rec.Result == "" {
+		rec.finish(s.buildpath, "ok", "")
+	}
+	return true
+}
 
-		// close the watcher
-		watcher.Close()
-		// to clean things up: read events from the watcher until events chan is closed.
-		go func(events chan *fsnotify.FileEvent) {
-			for _ = range events {
+// tampered asks for a rebuild after the binary was overwritten behind our back.
+func (s *session) tampered() {
+	go s.restartRequested()
+}
 
-			}
-		}(watcher.Event)
-		// create a new watcher
-		log.Println("rescanning")
-		watcher, err = getWatcher(buildpath)
-		if err != nil {
+// runSessions runs the loop of every session until ctx is cancelled or, for
+// each, until it quits, along with what they share: the sidecars, the
+// builders, the live reload server, the keys and the control API.
+func runSessions(ctx context.Context, sessions []*session) (err error) {
+	logSettings()
+	if *prefix_output || len(sessions) > 1 {
+		var names []string
+		for _, s := range sessions {
+			names = append(names, s.binName)
+		}
+		for _, sc := range sidecars {
+			names = append(names, sc.Name)
+		}
+		for _, b := range builders {
+			names = append(names, b.Name)
+		}
+		registerPrefixes(names...)
+	}
+	if err = startSidecars(ctx); err != nil {
+		return
+	}
+	// deferred, so that they go down after the programs.
+	defer stopSidecars()
+	gate.sessions = sessions
+	startBuilders(ctx)
+	if *livereload_addr != "" {
+		if err = startLiveReload(*livereload_addr, sessions); err != nil {
 			return
 		}
+	}
+	if *http_control != "" {
+		go serveControl(*http_control, sessions, false)
+	}
+	if *http_observe != "" {
+		go serveControl(*http_observe, sessions, true)
+	}
+	errs := make(chan error, len(sessions))
+	for _, s := range sessions {
+		var sctx context.Context
+		sctx, s.quit = context.WithCancel(ctx)
+		go func(s *session) {
+			defer s.quit()
+			errs <- s.loop(sctx)
+		}(s)
+	}
+	// the keys quit sessions, so they wait for every quit to be set.
+	startKeys(sessions)
+	defer restoreTerminal()
+	for range sessions {
+		if lerr := <-errs; lerr != nil {
+			if len(sessions) > 1 {
+				log.Print(lerr)
+			}
+			if err == nil {
+				err = lerr
+			}
+		}
+	}
+	return
+}
+
+// loop builds and runs the program, then rebuilds on every batch of changes
+// until ctx is cancelled.
+func (s *session) loop(ctx context.Context) (err error) {
+	s.journal.record(journalEntry{Event: "startup", Files: []string{s.buildpath}})
+	writePID(s.buildpath)
+	defer removePID(s.buildpath)
+	if err := logs.captureTo(s.binName, capturePath(s.buildpath)); err != nil {
+		log.Printf("not capturing output for rerun search: %s", err)
+	}
+	if !(*never_run) {
+		s.runch, s.stopped = s.run()
+	}
 
-		// we don't need the errors from the new watcher.
-		// we continiously discard them from the channel to avoid a deadlock.
-		go func(errors chan error) {
-			for _ = range errors {
+	if resuming != nil && resuming.Buildpath == s.buildpath {
+		s.resume()
+	}
+	// the program may embed or serve what the builders make.
+	waitBuilders(ctx)
+	s.rebuild(ctx, nil)
+	s.cycleDone()
 
-			}
-		}(watcher.Error)
+	events := s.events
+	var watcher io.Closer
+	watcher, err = s.getWatcher(events)
+	if err != nil {
+		return
+	}
+	s.logWatching()
 
-		var installed bool
-		// rebuild
-		installed, errorOutput, _ = install(buildpath, errorOutput)
-		if !installed {
-			continue
+	if *editor_stdio {
+		go readEditor(os.Stdin, events)
+	}
+	if *fifo_path != "" {
+		go serveFIFO(*fifo_path, events)
+	}
+	if *idle_after > 0 {
+		go idle.watch(ctx, *idle_after)
+	}
+	// other files in the directory don't count - we watch the whole thing in case new .go files appear.
+	// saves reported by an editor are taken at their word.
+	// changes the program makes itself don't count either, nor do the
+	// ones --exclude rules out, and neither do rerun's own outputs.
+	filter := watch.All(s.outputFilter(), builderFilter(),
+		watch.Any(watch.Ops(watch.Save), extFilter(), cgoFilter(), devEnvFilter(), ruleFilter(), execFilter(), protoFilter(), s.watchedFilter()),
+		s.written.filter(), s.globFilter(), s.before.filter())
+	var debouncer watch.Debouncer = watch.Windows(*debounce, debounce_for...)
+	if *burst_size > 0 || *burst_rate > 0 {
+		debouncer = watch.Bursts(debouncer, watch.Burst{Size: *burst_size, Rate: *burst_rate, Quiet: *burst_quiet})
+	}
+	batches := watch.Pipeline(ctx, events, counted(filter), debouncer)
+	go s.queue.fill(batches)
+	if *follow_addr != "" {
+		go s.queue.fill(follow(*follow_addr))
+	}
+	for {
+		batch, cycle, ok := s.queue.next(ctx)
+		if !ok {
+			break
 		}
-
-		if *do_tests {
-			passed, _ := test(buildpath)
-			if !passed {
-				continue
+		idle.touch()
+		var changed []string
+		for _, ev := range batch {
+			log.Print(ev.Name)
+			changed = append(changed, ev.Name)
+			if ev.Name == s.binPath || isDevEnvFile(ev.Name) || isDataFile(ev.Name) || isEnvFile(ev.Name) {
+				// the binary was tampered with, the environment changed or
+				// a file the program reads changed: rebuild and restart
+				// regardless.
+				s.runningHash = ""
 			}
 		}
 
-		if *do_build {
-			gobuild(buildpath)
+		if *ignore_self_writes {
+			s.written.cycle(names(batch))
 		}
+		focus.saw(changed)
+		s.followers.decided(changed)
+		s.saved = savedAt(batch)
+		s.rebuild(cycle, changed)
 
-		// rerun. if we're only testing, sending
-		if !(*never_run) {
-			runch <- true
+		// the edit may have changed what the program imports.
+		if structural(batch) {
+			log.Println("package files were added, removed or renamed, rescanning")
+			// the main package itself may have changed shape.
+			if p, ierr := build.Import(s.buildpath, "", 0); ierr == nil && p.Name != "main" {
+				log.Printf("expected package %q, got %q", "main", p.Name)
+			}
+			// close the watcher, its forwarding goroutine drains what is
+			// left, and start over: removed directories lost their watches.
+			watcher.Close()
+			watcher, err = s.getWatcher(events)
+		} else if s.last.Result != "compile error" {
+			watcher, err = s.rewatch(watcher, events)
 		}
+		if err != nil {
+			return
+		}
+		s.queue.done()
+		s.cycleDone()
+	}
+
+	// the context was cancelled: shut down cleanly.
+	watcher.Close()
+	if s.runch != nil {
+		log.Println("stopping", s.binName)
+		s.runMu.Lock()
+		close(s.runch)
+		s.runch = nil
+		s.runMu.Unlock()
+		<-s.stopped
+	}
+	s.journal.record(journalEntry{Event: "shutdown"})
+	s.journal.close()
+	s.reportFlakes()
+	s.latency.logReport()
+	if *exit_hook != "" {
+		s.runExitHook()
+	}
+	if *clean_on_exit {
+		created.remove()
 	}
 	return
 }
 
+func rerun(ctx context.Context, buildpath string, args []string) (err error) {
+	log.Printf("setting up %s %v", buildpath, args)
+
+	s, err := newSession("", buildpath, args)
+	if err != nil {
+		return
+	}
+	return runSessions(ctx, []*session{s})
+}
+
 func main() {
 	flag.Parse()
+	commandLine = os.Args[1:]
+
+	if flag.Arg(0) == "resume" {
+		err := loadSession(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if flag.Arg(0) == "init" {
+		err := scaffold(".rerun.toml")
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "daemon" {
+		addr := flag.Arg(1)
+		if addr == "" {
+			addr = defaultDaemonAddr()
+		}
+		err := serveDaemon(addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "doctor" {
+		if diagnose(flag.Arg(1)) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "attach" {
+		if flag.NArg() != 3 || flag.Arg(1) != "--observe" {
+			log.Fatal("Usage: rerun attach --observe <control address>")
+		}
+		err := observe(flag.Arg(2))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	if len(flag.Args()) < 1 {
-		log.Fatal("Usage: rerun [--test] [--no-run] [--build] [--race] <import path> [arg]*")
+	if flag.Arg(0) == "clean" {
+		fmt.Printf("removed %d file(s)\n", clean())
+		return
 	}
 
-	buildpath := flag.Args()[0]
-	args := flag.Args()[1:]
-	err := rerun(buildpath, args)
+	if flag.Arg(0) == "search" {
+		err := search(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "remote" {
+		err := remote(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "config" {
+		err := configCommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "checkpoint" {
+		err := checkpointCommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "bench" {
+		if flag.NArg() < 2 {
+			log.Fatal("Usage: rerun bench <import path> [base revision]")
+		}
+		base := "HEAD"
+		if flag.NArg() > 2 {
+			base = flag.Arg(2)
+		}
+		err := benchCompare(flag.Arg(1), base)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "history" {
+		args := flag.Args()[1:]
+		show := showHistory
+		if len(args) > 0 && (args[0] == "--process" || args[0] == "-process") {
+			args, show = args[1:], showProcessHistory
+		}
+		if len(args) < 1 {
+			log.Fatal("Usage: rerun history [--process] <import path> [count]")
+		}
+		n := 20
+		if len(args) > 1 {
+			fmt.Sscan(args[1], &n)
+		}
+		err := show(args[0], n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	buildpath, args, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	useBuildTags()
+	if flag.NArg() > 0 {
+		buildpath, args = flag.Arg(0), flag.Args()[1:]
+	}
+	if buildpath == "" && *exec_cmd != "" {
+		buildpath = *exec_cmd
+	}
+	if serving() {
+		log.SetOutput(io.MultiWriter(os.Stderr, hubWriter("rerun")))
+	}
+
+	if buildpath == "" && !multiProcess() && offerSetup() {
+		buildpath, args, err = loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if buildpath == "" && !multiProcess() {
+		log.Fatal("Usage: rerun [flags] <import path> [arg]*, or set path in .rerun.toml")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	var caught os.Signal
+	go func() {
+		caught = <-sigs
+		log.Printf("caught %s, shutting down (interrupt again to force quit)", caught)
+		cancel()
+		sig := <-sigs
+		log.Printf("caught %s again, killing the program and quitting without cleanup", sig)
+		killChildren()
+		killSidecars()
+		restoreTerminal()
+		os.Exit(exitCode(sig))
+	}()
+
+	if multiProcess() {
+		if flag.NArg() > 0 {
+			log.Fatal("an import path can't be given along with --proc or --procfile")
+		}
+		err = runProcs(ctx)
+	} else {
+		err = rerun(ctx, buildpath, args)
+	}
 	if err != nil {
 		log.Print(err)
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		os.Exit(exitCode(caught))
+	}
+	// the program exited and --exit-on-child-exit passes its code on.
+	os.Exit(childStatus)
+}
+
+// exitCode follows the shell convention for a process ended by a signal.
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
 	}
+	return 1
 }
@@ -0,0 +1,84 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+var (
+	restart_on_exit    = flag.Bool("restart-on-exit", false, "Start the program again when it crashes or exits with an error, backing off exponentially")
+	restart_max        = flag.Int("restart-max", 0, "With --restart-on-exit, give up after this many restarts in a row (default no limit)")
+	exit_on_child_exit = flag.Bool("exit-on-child-exit", false, "Exit when the program exits on its own, with its exit code")
+)
+
+// childStatus is the exit code rerun passes on with --exit-on-child-exit.
+var childStatus int
+
+const (
+	// the first restart after a crash waits restartBackoff, each one after
+	// that twice as long as the last, up to maxRestartBackoff.
+	restartBackoff    = 500 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+	// a program that ran for stableAfter before it crashed starts over with
+	// the shortest backoff.
+	stableAfter = time.Minute
+)
+
+// exitDescription says how the program exited, given what Wait returned.
+func exitDescription(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return err.Error()
+	}
+	return "error: " + err.Error()
+}
+
+// childExitCode is the exit code of a program that exited as Wait says,
+// following the shell convention for one ended by a signal.
+func childExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return 1
+	}
+	if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return exitCode(ws.Signal())
+	}
+	return ee.ExitCode()
+}
+
+// restartDelay is how long to wait before restart number n (from 0) in a row.
+func restartDelay(n int) time.Duration {
+	d := restartBackoff
+	for i := 0; i < n && d < maxRestartBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRestartBackoff {
+		d = maxRestartBackoff
+	}
+	return d
+}
+
+// crashed decides what to do after the program failed on its own for the
+// crashes-th time in a row: it returns when to start it again, or nil to
+// leave it down until the next change.
+func (s *session) crashed(crashes int) <-chan time.Time {
+	if *restart_max > 0 && crashes >= *restart_max {
+		log.Printf("%s failed %d times in a row, not restarting it until the next change", s.binName, crashes+1)
+		return nil
+	}
+	d := restartDelay(crashes)
+	log.Printf("restarting %s in %s", s.binName, d)
+	return time.After(d)
+}
@@ -0,0 +1,151 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A savedSession is what `rerun resume` needs to pick up where a session
+// left off.
+type savedSession struct {
+	Args      []string  `json:"args"`
+	Dir       string    `json:"dir"`
+	Buildpath string    `json:"buildpath"`
+	Binary    string    `json:"binary,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	Failing   []string  `json:"failing,omitempty"`
+	Watching  []string  `json:"watching,omitempty"`
+	Cycles    int       `json:"cycles"`
+	Failures  int       `json:"failures"`
+	Saved     time.Time `json:"saved"`
+}
+
+// commandLine is how this session was started, flags included.
+var commandLine []string
+
+// resuming is the saved session being resumed, if any.
+var resuming *savedSession
+
+func sessionPath(key string) string {
+	return filepath.Join(stateDir(), "session", key+".json")
+}
+
+// saveState persists the session after a cycle.
+func (s *session) saveState(rec *cycleRecord) {
+	if rec.Result != "ok" {
+		s.failures++
+	}
+	saved := savedSession{
+		Args:      commandLine,
+		Buildpath: s.buildpath,
+		Failing:   s.failing,
+		Cycles:    s.cycle,
+		Failures:  s.failures,
+		Saved:     time.Now(),
+	}
+	saved.Dir, _ = os.Getwd()
+	if s.runningHash != "" {
+		saved.Binary, saved.Hash = s.binPath, s.runningHash
+	}
+	for _, pkg := range s.graph {
+		if !pkg.Goroot {
+			saved.Watching = append(saved.Watching, pkg.Dir)
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		name := sessionPath(projectKey(s.buildpath))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		err = ioutil.WriteFile(name, data, 0644)
+		if err == nil {
+			err = ioutil.WriteFile(sessionPath("last"), data, 0644)
+		}
+	}
+	if err != nil {
+		log.Printf("error saving session: %s", err)
+	}
+}
+
+// loadSession reads the saved session for buildpath, or the most recent one
+// if buildpath is empty, and restores its flags and working directory.
+func loadSession(buildpath string) (err error) {
+	key := "last"
+	if buildpath != "" {
+		key = projectKey(buildpath)
+	}
+	data, err := ioutil.ReadFile(sessionPath(key))
+	if os.IsNotExist(err) {
+		return errors.New("no saved session to resume")
+	}
+	if err != nil {
+		return
+	}
+	saved := &savedSession{}
+	err = json.Unmarshal(data, saved)
+	if err != nil {
+		return
+	}
+	if saved.Dir != "" {
+		err = os.Chdir(saved.Dir)
+		if err != nil {
+			return
+		}
+	}
+	err = flag.CommandLine.Parse(saved.Args)
+	if err != nil {
+		return
+	}
+	commandLine = saved.Args
+	resuming = saved
+	log.Printf("resuming %s (%d cycles, %d failures, saved %s)", saved.Buildpath, saved.Cycles,
+		saved.Failures, saved.Saved.Format(time.Stamp))
+	if len(saved.Failing) > 0 {
+		log.Printf("failing when the session ended: %s", strings.Join(saved.Failing, ", "))
+	}
+	return
+}
+
+// resume starts the binary the saved session was running, if it is still
+// the same, so the program is up before the first rebuild has finished.
+func (s *session) resume() {
+	saved := resuming
+	s.cycle = saved.Cycles
+	s.failures = saved.Failures
+	s.failing = saved.Failing
+	if saved.Hash == "" || saved.Binary != s.binPath || s.runch == nil {
+		return
+	}
+	if sum, err := hashFile(s.binPath); err != nil || sum != saved.Hash {
+		log.Printf("%s changed since the session was saved, rebuilding first", s.binPath)
+		return
+	}
+	log.Printf("starting the previous %s while rebuilding", s.binName)
+	// with --build-id-var there is no copy of it to compare builds with,
+	// and the first rebuild restarts it.
+	s.runningHash = saved.Hash
+	s.runch <- launch{relaunch: true, hash: saved.Hash, cycle: s.cycle}
+}
+
+var failLine = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+
+// failingTests picks the names of failed tests out of go test -v output.
+func failingTests(output string) (names []string) {
+	for _, line := range strings.Split(output, "\n") {
+		if m := failLine.FindStringSubmatch(line); m != nil {
+			names = append(names, m[1])
+		}
+	}
+	return
+}
@@ -0,0 +1,151 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// rule runs a command before the build whenever a matching file changes,
+// while its label (if any) is on.
+type rule struct {
+	Label   string
+	Pattern string
+	Command string
+}
+
+// ruleList is a repeatable "pattern=command" flag.
+type ruleList []rule
+
+func (l *ruleList) String() string {
+	var parts []string
+	for _, r := range *l {
+		part := r.Pattern + "=" + r.Command
+		if r.Label != "" {
+			part = "@" + r.Label + " " + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *ruleList) Set(value string) error {
+	label, value := labelled(value)
+	i := strings.Index(value, "=")
+	if i <= 0 || strings.TrimSpace(value[i+1:]) == "" {
+		return fmt.Errorf("expected pattern=command, got %q", value)
+	}
+	*l = append(*l, rule{Label: label, Pattern: value[:i], Command: value[i+1:]})
+	return nil
+}
+
+// presets are ready-made rules for common code generators.
+var presets = map[string][]rule{
+	"gqlgen": {
+		{Pattern: "*.graphqls", Command: "go run github.com/99designs/gqlgen generate"},
+		{Pattern: "gqlgen.yml", Command: "go run github.com/99designs/gqlgen generate"},
+	},
+	"oapi-codegen": {
+		{Pattern: "openapi.yaml", Command: "go generate ./..."},
+		{Pattern: "openapi.yml", Command: "go generate ./..."},
+		{Pattern: "openapi.json", Command: "go generate ./..."},
+	},
+}
+
+// presetList is a repeatable flag naming entries in presets.
+type presetList []string
+
+func (l *presetList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *presetList) Set(value string) error {
+	if _, ok := presets[value]; !ok {
+		var names []string
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown preset %q (have %s)", value, strings.Join(names, ", "))
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+var (
+	rules       ruleList
+	use_presets presetList
+)
+
+func init() {
+	flag.Var(&rules, "rule", "Run a command before the build when a matching file changes, as [@label ]pattern=command (repeatable)")
+	flag.Var(&use_presets, "preset", "Enable the built-in rules for a code generator: gqlgen or oapi-codegen (repeatable)")
+}
+
+// allRules are the rules given with --rule followed by those of the enabled
+// presets.
+func allRules() (all []rule) {
+	all = append(all, rules...)
+	for _, name := range use_presets {
+		all = append(all, presets[name]...)
+	}
+	return
+}
+
+// ruleFilter keeps changes to files that some rule is interested in.
+func ruleFilter() watch.EventFilter {
+	all := allRules()
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		for _, r := range all {
+			if watch.Match(r.Pattern, ev.Name) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// runRules runs, once each and in order, the commands of the rules matching
+// the changed files. It reports whether they all succeeded.
+func (s *session) runRules(rec *cycleRecord, why *cycleReason, changed []string) (ok bool) {
+	ran := map[string]bool{}
+	for _, r := range allRules() {
+		if ran[r.Command] || !labels.on(r.Label) || !anyMatch(r.Pattern, changed) {
+			continue
+		}
+		ran[r.Command] = true
+		fields := strings.Fields(r.Command)
+		start := time.Now()
+		cmd := command(fields[0], fields[1:]...)
+		cmd.Dir = s.dir
+		log.Printf("%s changed, running %s", r.Pattern, r.Command)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Printf("error running %s: %s\n%s", r.Command, err, out)
+			s.step(why, r.Command, start, "failed")
+			rec.finish(s.buildpath, "rule failure", string(out))
+			return
+		}
+		s.step(why, r.Command, start, "ok")
+	}
+	return true
+}
+
+func anyMatch(pattern string, names []string) bool {
+	for _, name := range names {
+		if watch.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var test_sandbox = flag.Bool("test-sandbox", false, "Run the tests in a throwaway copy of the module (outside modules, of the package), so files they write neither trigger a rebuild nor land in the working tree")
+
+// A sandbox is a throwaway copy of the source tree that tests run in.
+type sandbox struct {
+	root   string // the temporary directory holding the copy
+	src    string // the directory that was copied
+	dir    string // its copy
+	gopath bool   // the copy is a GOPATH workspace at root
+}
+
+// newSandbox copies the module holding the package at buildpath or, outside
+// modules, the package itself into a temporary directory.
+func newSandbox(buildpath string) (sb *sandbox, err error) {
+	pkg, err := build.Import(buildpath, "", build.FindOnly)
+	if err != nil {
+		return
+	}
+	root, err := ioutil.TempDir("", "rerun-test")
+	if err != nil {
+		return
+	}
+	sb = &sandbox{root: root}
+	if mod := modRoot(pkg.Dir); mod != "" {
+		sb.src, sb.dir = mod, filepath.Join(root, filepath.Base(mod))
+	} else {
+		sb.src, sb.dir = pkg.Dir, filepath.Join(root, "src", filepath.FromSlash(pkg.ImportPath))
+		sb.gopath = true
+	}
+	if err = copyTree(sb.src, sb.dir); err != nil {
+		sb.remove()
+		sb = nil
+	}
+	return
+}
+
+// setup points cmd, about to test buildpath, at the copy and returns the path
+// to give it in place of buildpath.
+func (sb *sandbox) setup(cmd *exec.Cmd, buildpath string) (target string) {
+	if sb.gopath {
+		cmd.Dir = sb.dir
+		cmd.Env = append(cmd.Env, "GOPATH="+sb.root+string(filepath.ListSeparator)+build.Default.GOPATH)
+		return "."
+	}
+	// relative paths and the main module resolve from the working directory.
+	cmd.Dir = sb.dir
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(sb.src, wd); err == nil && !strings.HasPrefix(rel, "..") {
+			cmd.Dir = filepath.Join(sb.dir, rel)
+		}
+	}
+	return buildpath
+}
+
+// mapBack rewrites the paths into the copy in output to the original ones.
+func (sb *sandbox) mapBack(output []byte) []byte {
+	return []byte(strings.Replace(string(output), sb.dir, sb.src, -1))
+}
+
+func (sb *sandbox) remove() {
+	os.RemoveAll(sb.root)
+}
+
+// copyTree copies the files under src to dst, leaving out hidden directories
+// such as .git.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			if rel != "." && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			return copyFile(name, target, fi.Mode())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return
+	}
+	return out.Close()
+}
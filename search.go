@@ -0,0 +1,173 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// A logQuery picks lines out of a captured session.
+type logQuery struct {
+	Pattern *regexp.Regexp
+	Cycle   int
+	Build   int
+	Since   time.Time
+	Until   time.Time
+}
+
+func (q logQuery) matches(l logLine) bool {
+	if q.Cycle != 0 && l.Cycle != q.Cycle {
+		return false
+	}
+	if q.Build != 0 && l.Build != q.Build {
+		return false
+	}
+	if !q.Since.IsZero() && l.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && l.Time.After(q.Until) {
+		return false
+	}
+	return q.Pattern == nil || q.Pattern.MatchString(l.Line)
+}
+
+// parseWhen reads a point in time: a duration ago ("10m"), a time of day
+// today ("15:04" or "15:04:05") or an RFC 3339 timestamp.
+func parseWhen(s string) (t time.Time, err error) {
+	if s == "" {
+		return
+	}
+	if d, derr := time.ParseDuration(s); derr == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if c, cerr := time.ParseInLocation(layout, s, time.Local); cerr == nil {
+			y, m, d := time.Now().Date()
+			return time.Date(y, m, d, c.Hour(), c.Minute(), c.Second(), 0, time.Local), nil
+		}
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		err = fmt.Errorf("expected a duration, a time of day or an RFC 3339 time, got %q", s)
+	}
+	return
+}
+
+// newLogQuery builds a query from its textual parts; empty parts match
+// everything.
+func newLogQuery(pattern, cycle, build, since, until string) (q logQuery, err error) {
+	if pattern != "" {
+		q.Pattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return
+		}
+	}
+	if cycle != "" {
+		q.Cycle, err = strconv.Atoi(cycle)
+		if err != nil {
+			return
+		}
+	}
+	if build != "" {
+		q.Build, err = strconv.Atoi(build)
+		if err != nil {
+			return
+		}
+	}
+	q.Since, err = parseWhen(since)
+	if err != nil {
+		return
+	}
+	q.Until, err = parseWhen(until)
+	return
+}
+
+// searchLogs returns the captured lines of the session for buildpath that
+// match q, oldest first.
+func searchLogs(buildpath string, q logQuery) (found []logLine, err error) {
+	path := capturePath(buildpath)
+	for _, name := range []string{path + ".old", path} {
+		f, oerr := os.Open(name)
+		if os.IsNotExist(oerr) {
+			continue
+		}
+		if oerr != nil {
+			return nil, oerr
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 2*maxLine)
+		for scanner.Scan() {
+			var l logLine
+			if json.Unmarshal(scanner.Bytes(), &l) == nil && q.matches(l) {
+				found = append(found, l)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// search is the rerun search command.
+func search(args []string) (err error) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	cycle := fs.String("cycle", "", "Only lines from the program started by this cycle")
+	build := fs.String("build", "", "Only lines from the program of this build ID")
+	since := fs.String("since", "", "Only lines from after this time (10m, 15:04 or RFC 3339)")
+	until := fs.String("until", "", "Only lines from before this time")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rerun search [--cycle n] [--build id] [--since t] [--until t] <import path> [regex]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	q, err := newLogQuery(fs.Arg(1), *cycle, *build, *since, *until)
+	if err != nil {
+		return
+	}
+	found, err := searchLogs(fs.Arg(0), q)
+	if err != nil {
+		return
+	}
+	for _, l := range found {
+		fmt.Printf("%s cycle %d build %d %s | %s\n", l.Time.Format("15:04:05.000"), l.Cycle, l.Build, l.Source, l.Line)
+	}
+	return
+}
+
+// serveSearch answers GET /search?re=&cycle=&build=&since=&until= for s.
+func (s *session) serveSearch(w http.ResponseWriter, r *http.Request) {
+	q, err := newLogQuery(r.FormValue("re"), r.FormValue("cycle"), r.FormValue("build"), r.FormValue("since"), r.FormValue("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	found, err := searchLogs(s.buildpath, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var own []logLine
+	for _, l := range found {
+		if s.owns(l) {
+			own = append(own, l)
+		}
+	}
+	writeJSON(w, own)
+}
@@ -0,0 +1,213 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+var ignore_self_writes = flag.Bool("ignore-self-writes", true, "Learn and ignore the files the program itself writes into watched directories")
+
+// selfWriteWindow is how soon after a restart a change must come to count
+// towards a feedback loop, and selfWriteRepeats how often in a row.
+const (
+	selfWriteWindow  = 2 * time.Second
+	selfWriteRepeats = 3
+)
+
+// selfWrites remembers the files the program writes itself, so that they
+// don't trigger a rebuild that restarts the program that writes them again.
+type selfWrites struct {
+	mu      sync.Mutex
+	paths   map[string]bool
+	started time.Time
+	last    string
+	repeats int
+}
+
+func newSelfWrites() *selfWrites {
+	return &selfWrites{paths: map[string]bool{}}
+}
+
+// learned is name, or the directory above it, that was learned, or "".
+func (w *selfWrites) learned(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for p := name; ; p = filepath.Dir(p) {
+		if w.paths[p] {
+			return p
+		}
+		if filepath.Dir(p) == p {
+			return ""
+		}
+	}
+}
+
+// learn ignores name from now on, unless a package is built from it: those
+// are the user's to edit, however quickly they change.
+func (w *selfWrites) learn(name, why string) {
+	if packageFile(name) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.paths[name] {
+		log.Printf("ignoring %s from now on: %s", name, why)
+		w.paths[name] = true
+	}
+}
+
+// forget stops ignoring name.
+func (w *selfWrites) forget(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paths[name] {
+		log.Printf("no longer ignoring %s: it changed while the program wasn't running", name)
+		delete(w.paths, name)
+	}
+}
+
+// packageFile reports whether name is a Go file, or one that the package in
+// its directory is built from or embeds.
+func packageFile(name string) bool {
+	if filepath.Ext(name) == ".go" {
+		return true
+	}
+	pkg, err := build.ImportDir(filepath.Dir(name), 0)
+	if err != nil {
+		return false
+	}
+	base := filepath.Base(name)
+	for _, files := range [][]string{pkg.CFiles, pkg.CXXFiles, pkg.MFiles, pkg.HFiles, pkg.FFiles, pkg.SFiles, pkg.SwigFiles, pkg.SwigCXXFiles, pkg.SysoFiles} {
+		for _, f := range files {
+			if f == base {
+				return true
+			}
+		}
+	}
+	rel, err := filepath.Rel(pkg.Dir, name)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range pkg.EmbedPatterns {
+		pattern = strings.TrimPrefix(pattern, "all:")
+		// a pattern naming a directory embeds everything below it.
+		for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// childRunning reports whether any program rerun started is running.
+func childRunning() bool {
+	children.Lock()
+	defer children.Unlock()
+	return len(children.procs) > 0
+}
+
+// childStarted marks the start of a new program.
+func (w *selfWrites) childStarted() {
+	w.mu.Lock()
+	w.started = time.Now()
+	w.mu.Unlock()
+}
+
+// cycle looks for feedback loops: the very same files changing right after
+// each of several restarts in a row are taken to be written by the program.
+func (w *selfWrites) cycle(names []string) {
+	w.mu.Lock()
+	key := strings.Join(names, "\n")
+	if w.started.IsZero() || time.Since(w.started) > selfWriteWindow || key != w.last {
+		w.last, w.repeats = key, 0
+	}
+	w.repeats++
+	loop := w.repeats >= selfWriteRepeats
+	w.mu.Unlock()
+	if !loop {
+		return
+	}
+	for _, name := range names {
+		w.learn(name, fmt.Sprintf("it changed right after each of the last %d restarts", selfWriteRepeats))
+	}
+}
+
+// openForWriting reports whether a running child has name open for
+// writing. This relies on /proc, so elsewhere it never finds anything.
+func openForWriting(name string) bool {
+	children.Lock()
+	var pids []int
+	for proc := range children.procs {
+		pids = append(pids, proc.Pid)
+	}
+	children.Unlock()
+	for _, pid := range pids {
+		dir := fmt.Sprintf("/proc/%d/fd", pid)
+		fds, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || target != name {
+				continue
+			}
+			info, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, fd.Name()))
+			if err != nil {
+				continue
+			}
+			var flags int
+			for _, line := range strings.Split(string(info), "\n") {
+				if strings.HasPrefix(line, "flags:") {
+					fmt.Sscanf(strings.TrimSpace(line[len("flags:"):]), "%o", &flags)
+				}
+			}
+			if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filter drops the changes to learned files, and learns the files a running
+// child has open for writing. Explicit saves are kept, and a learned file
+// that changes while no program runs can't be the program's and is
+// forgotten.
+func (w *selfWrites) filter() watch.EventFilter {
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		if !*ignore_self_writes || ev.Op&watch.Save != 0 {
+			return true
+		}
+		if p := w.learned(ev.Name); p != "" {
+			if childRunning() {
+				return false
+			}
+			w.forget(p)
+			return true
+		}
+		if openForWriting(ev.Name) {
+			w.learn(ev.Name, "the program has it open for writing")
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,387 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	test_workers         stringList
+	test_worker_platform = flag.String("test-worker-platform", runtime.GOOS+"/"+runtime.GOARCH, "The GOOS/GOARCH of the --test-worker machines")
+)
+
+func init() {
+	flag.Var(&test_workers, "test-worker", "With --test, split the tests across this machine, reached over ssh as [user@]host, and the other workers (repeatable)")
+}
+
+// testBinaryFlags are the go test flags the test binary itself takes, as
+// -test.<name>; the others are for building it.
+var testBinaryFlags = map[string]bool{
+	"bench": true, "benchmem": true, "benchtime": true, "count": true, "cpu": true, "failfast": true,
+	"parallel": true, "run": true, "short": true, "shuffle": true, "skip": true, "timeout": true, "v": true,
+}
+
+// valueFlags are the go test and build flags that take a value, which may
+// be the next argument.
+var valueFlags = map[string]bool{
+	"bench": true, "benchtime": true, "blockprofile": true, "blockprofilerate": true, "count": true,
+	"coverprofile": true, "covermode": true, "coverpkg": true, "cpu": true, "cpuprofile": true,
+	"fuzz": true, "fuzztime": true, "fuzzminimizetime": true, "list": true, "memprofile": true,
+	"memprofilerate": true, "mutexprofile": true, "mutexprofilefraction": true, "outputdir": true,
+	"parallel": true, "run": true, "skip": true, "shuffle": true, "timeout": true, "trace": true,
+	"o": true, "exec": true, "tags": true, "ldflags": true, "gcflags": true, "asmflags": true,
+	"mod": true, "modfile": true, "overlay": true, "pkgdir": true, "p": true, "toolexec": true,
+	"vet": true, "buildmode": true, "compiler": true, "installsuffix": true, "pgo": true,
+}
+
+// splitTestFlags sorts --testflags into the ones for building the test
+// binary and the ones for running it.
+func splitTestFlags(args []string) (buildArgs, runArgs []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name := strings.TrimLeft(arg, "-")
+		value := ""
+		hasValue := strings.Contains(name, "=")
+		if hasValue {
+			name, value = name[:strings.Index(name, "=")], name[strings.Index(name, "=")+1:]
+		} else if valueFlags[name] && i+1 < len(args) {
+			i++
+			value, hasValue = args[i], true
+		}
+		if testBinaryFlags[name] {
+			runArgs = append(runArgs, "-test."+name)
+			if hasValue {
+				runArgs = append(runArgs, value)
+			}
+			continue
+		}
+		buildArgs = append(buildArgs, "-"+name)
+		if hasValue {
+			buildArgs = append(buildArgs, value)
+		}
+	}
+	return
+}
+
+// testTimes remembers how long every test took the last time it ran, to
+// balance the shards.
+var testTimes = struct {
+	sync.Mutex
+	took map[string]float64
+}{took: map[string]float64{}}
+
+var timedResult = regexp.MustCompile(`^--- (?:PASS|FAIL|SKIP): (\S+) \(([\d.]+)s\)`)
+
+func recordTestTimes(buildpath, output string) {
+	testTimes.Lock()
+	defer testTimes.Unlock()
+	for _, line := range strings.Split(output, "\n") {
+		if m := timedResult.FindStringSubmatch(line); m != nil {
+			secs, _ := strconv.ParseFloat(m[2], 64)
+			testTimes.took[buildpath+"."+m[1]] = secs
+		}
+	}
+}
+
+// assignShards splits tests into at most n shards that should take about
+// as long as one another, longest first. Tests that never ran count as
+// average.
+func assignShards(buildpath string, tests []string, n int) (shards [][]string) {
+	testTimes.Lock()
+	took := map[string]float64{}
+	var sum float64
+	var known int
+	for _, t := range tests {
+		if secs, ok := testTimes.took[buildpath+"."+t]; ok {
+			took[t] = secs
+			sum += secs
+			known++
+		}
+	}
+	testTimes.Unlock()
+	avg := 0.1
+	if known > 0 {
+		avg = sum / float64(known)
+	}
+	for _, t := range tests {
+		if _, ok := took[t]; !ok {
+			took[t] = avg
+		}
+	}
+	sorted := append([]string{}, tests...)
+	sort.SliceStable(sorted, func(i, j int) bool { return took[sorted[i]] > took[sorted[j]] })
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	shards = make([][]string, n)
+	load := make([]float64, n)
+	for _, t := range sorted {
+		least := 0
+		for i := range load {
+			if load[i] < load[least] {
+				least = i
+			}
+		}
+		shards[least] = append(shards[least], t)
+		load[least] += took[t]
+	}
+	return
+}
+
+// takeRun removes -test.run from runArgs, returning the pattern it had.
+func takeRun(runArgs []string) (rest []string, run string) {
+	for i := 0; i < len(runArgs); i++ {
+		if runArgs[i] == "-test.run" && i+1 < len(runArgs) {
+			run = runArgs[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, runArgs[i])
+	}
+	return
+}
+
+// splitRun splits a -run pattern at its slashes outside brackets and
+// parentheses, as go test does: the first part picks tests, the others
+// subtests.
+func splitRun(run string) (top, sub string) {
+	depth := 0
+	for i := 0; i < len(run); i++ {
+		switch run[i] {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case '\\':
+			i++
+		case '/':
+			if depth == 0 {
+				return run[:i], run[i:]
+			}
+		}
+	}
+	return run, ""
+}
+
+// matchingTests keeps the tests the top level of a -run pattern picks.
+func matchingTests(tests []string, top string) (kept []string, err error) {
+	re, err := regexp.Compile(top)
+	if err != nil {
+		return
+	}
+	for _, t := range tests {
+		if re.MatchString(t) {
+			kept = append(kept, t)
+		}
+	}
+	return
+}
+
+// listTests lists the tests, examples and fuzz targets of buildpath.
+func listTests(ctx context.Context, buildpath string, buildArgs []string) (tests []string, err error) {
+	cmd := command("go", append(append(append([]string{"test", "-list", "."}, buildFlags(0)...), buildArgs...), buildpath)...)
+	cmd.Env = installEnv()
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err = runCycleCmd(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("%s\n%s", err, out.String())
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "Test") || strings.HasPrefix(line, "Example") || strings.HasPrefix(line, "Fuzz") {
+			tests = append(tests, line)
+		}
+	}
+	return
+}
+
+// testBundle is what a worker gets: the test binary, as rerun.test, along
+// with the files of the package directory and its testdata, which the
+// tests may read.
+func testBundle(ctx context.Context, buildpath string, buildArgs []string) (bundle []byte, err error) {
+	pkg, err := build.Import(buildpath, "", build.FindOnly)
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempDir("", "rerun-shard-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	bin := filepath.Join(tmp, "rerun.test")
+	args := []string{"test", "-c", "-o", bin}
+	if *race_detector {
+		args = append(args, "-race")
+	}
+	args = append(append(append(args, buildFlags(0)...), buildArgs...), buildpath)
+	cmd := command("go", args...)
+	platform := strings.SplitN(*test_worker_platform, "/", 2)
+	cmd.Env = append(installEnv(), "GOOS="+platform[0])
+	if len(platform) == 2 {
+		cmd.Env = append(cmd.Env, "GOARCH="+platform[1])
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err = runCycleCmd(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("%s\n%s", err, out.String())
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(path, name string, fi os.FileInfo) error {
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}
+	fi, err := os.Stat(bin)
+	if err != nil {
+		return
+	}
+	if err = add(bin, "rerun.test", fi); err != nil {
+		return
+	}
+	err = filepath.Walk(pkg.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(pkg.Dir, path)
+		switch {
+		case rel == ".":
+			return nil
+		case fi.IsDir() && rel != "testdata" && !strings.HasPrefix(rel, "testdata"+string(filepath.Separator)):
+			return filepath.SkipDir
+		case !fi.IsDir() && !fi.Mode().IsRegular():
+			return nil
+		}
+		return add(path, rel, fi)
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	return buf.Bytes(), err
+}
+
+// shardScript unpacks the bundle in a scratch directory on the worker and
+// runs the test binary there.
+func shardScript(runArgs []string) string {
+	return `d=$(mktemp -d) && cd "$d" && tar xf - && ./rerun.test ` + shellQuote(runArgs) +
+		`; s=$?; cd / && rm -rf "$d"; exit $s`
+}
+
+// testSharded runs the tests of buildpath split across the --test-worker
+// machines, and merges what they report as if go test had run them all.
+func testSharded(ctx context.Context, buildpath string, cycle int) (passed bool, output string, err error) {
+	start := time.Now()
+	buildArgs, runArgs := splitTestFlags(test_flags)
+	// a -run of the user's narrows the shards down rather than replacing
+	// them.
+	runArgs, run := takeRun(runArgs)
+	top, sub := splitRun(run)
+	tests, err := listTests(ctx, buildpath, buildArgs)
+	if err == nil && top != "" {
+		tests, err = matchingTests(tests, top)
+	}
+	if err == nil && len(tests) == 0 {
+		log.Println("no tests to run")
+		return true, "", nil
+	}
+	var bundle []byte
+	if err == nil {
+		bundle, err = testBundle(ctx, buildpath, buildArgs)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		output = err.Error()
+		fmt.Println(output)
+		return
+	}
+	shards := assignShards(buildpath, tests, len(test_workers))
+	outputs := make([]string, len(shards))
+	oks := make([]bool, len(shards))
+	took := make([]time.Duration, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, host string, shard []string) {
+			defer wg.Done()
+			shardStart := time.Now()
+			args := append([]string{"-test.v", "-test.run", "^(" + strings.Join(shard, "|") + ")$" + sub}, runArgs...)
+			cmd := exec.Command("ssh", "-o", "BatchMode=yes", host, shardScript(args))
+			cmd.Stdin = bytes.NewReader(bundle)
+			var out bytes.Buffer
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			serr := runCycleCmd(ctx, cmd)
+			took[i] = time.Since(shardStart)
+			if ee, ok := serr.(*exec.ExitError); ok && ee.ExitCode() == 255 {
+				// ssh itself failed, not the tests.
+				fmt.Fprintf(&out, "\nrerun: could not run %d test(s) on %s: %s\n", len(shard), host, serr)
+			}
+			outputs[i], oks[i] = out.String(), serr == nil
+		}(i, test_workers[i], shard)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	passed = true
+	var slowest int
+	for i := range shards {
+		passed = passed && oks[i]
+		if took[i] > took[slowest] {
+			slowest = i
+		}
+	}
+	output = strings.Join(outputs, "\n")
+	recordTestTimes(buildpath, output)
+	if *trace_build {
+		saveTrace(buildpath, cycle, "test", []byte(output))
+	}
+	log.Printf("%d tests ran on %d workers in %s (slowest: %s, %s)", len(tests), len(shards),
+		time.Since(start).Round(time.Millisecond), test_workers[slowest], took[slowest].Round(time.Millisecond))
+	if !passed {
+		fmt.Println(output)
+	} else {
+		log.Println("tests passed")
+	}
+	return
+}
@@ -0,0 +1,184 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A sidecar is a service the program needs, such as a database, that rerun
+// starts once before the first run and keeps up across rebuilds.
+type sidecar struct {
+	Name    string
+	Addr    string // where it accepts connections once ready, or ""
+	Command string
+
+	cmd      *exec.Cmd
+	exited   chan bool
+	stopping bool
+}
+
+type sidecarList []*sidecar
+
+func (l *sidecarList) String() string {
+	var parts []string
+	for _, sc := range *l {
+		parts = append(parts, sc.Name+"="+sc.Command)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *sidecarList) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return errors.New("expected name[@host:port]=command")
+	}
+	sc := &sidecar{Name: value[:i], Command: value[i+1:]}
+	if j := strings.Index(sc.Name, "@"); j >= 0 {
+		sc.Name, sc.Addr = sc.Name[:j], sc.Name[j+1:]
+	}
+	*l = append(*l, sc)
+	return nil
+}
+
+var (
+	sidecars        sidecarList
+	sidecarsMu      sync.Mutex
+	sidecar_timeout = flag.Duration("sidecar-timeout", time.Minute, "How long to wait for a --sidecar to accept connections")
+)
+
+func init() {
+	flag.Var(&sidecars, "sidecar", "Start a service the program needs before the first run and stop it on exit, as name[@host:port]=command; with an address, wait until it accepts connections (repeatable)")
+}
+
+// startSidecars starts every sidecar and waits until each is ready. If one
+// fails, the ones already started are stopped again.
+func startSidecars(ctx context.Context) (err error) {
+	for _, sc := range sidecars {
+		err = sc.start()
+		if err == nil {
+			err = sc.wait(ctx)
+		}
+		if err != nil {
+			stopSidecars()
+			return fmt.Errorf("sidecar %s: %s", sc.Name, err)
+		}
+	}
+	return
+}
+
+func (sc *sidecar) start() (err error) {
+	cmd := shellCommand(sc.Command)
+	cmd.Stdout = namedOutput(os.Stdout, sc.Name, "stdout")
+	cmd.Stderr = namedOutput(os.Stderr, sc.Name, "stderr")
+	log.Printf("starting sidecar %s: %s", sc.Name, sc.Command)
+	ownGroup(cmd)
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	joinGroup(cmd.Process)
+	sidecarsMu.Lock()
+	sc.cmd, sc.exited = cmd, make(chan bool)
+	sidecarsMu.Unlock()
+	go func() {
+		err := cmd.Wait()
+		sidecarsMu.Lock()
+		stopping := sc.stopping
+		sidecarsMu.Unlock()
+		if !stopping {
+			log.Printf("sidecar %s exited: %v", sc.Name, err)
+		}
+		close(sc.exited)
+	}()
+	return
+}
+
+// wait blocks until the sidecar accepts connections on its address.
+func (sc *sidecar) wait(ctx context.Context) error {
+	if sc.Addr == "" {
+		return nil
+	}
+	deadline := time.Now().Add(*sidecar_timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", sc.Addr, time.Second)
+		if err == nil {
+			conn.Close()
+			log.Printf("sidecar %s is ready on %s", sc.Name, sc.Addr)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not ready on %s after %s", sc.Addr, *sidecar_timeout)
+		}
+		select {
+		case <-sc.exited:
+			return errors.New("exited before it was ready")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// stopSidecars stops the running sidecars in reverse order, giving each
+// --kill-timeout to exit.
+func stopSidecars() {
+	for i := len(sidecars) - 1; i >= 0; i-- {
+		sc := sidecars[i]
+		sidecarsMu.Lock()
+		cmd := sc.cmd
+		sc.stopping = true
+		sidecarsMu.Unlock()
+		if cmd == nil {
+			continue
+		}
+		log.Printf("stopping sidecar %s", sc.Name)
+		if signalGroup(cmd.Process, os.Interrupt) != nil {
+			killGroup(cmd.Process)
+		}
+		select {
+		case <-sc.exited:
+		case <-time.After(*kill_timeout):
+			log.Printf("sidecar %s did not exit within %s, killing it", sc.Name, *kill_timeout)
+			killGroup(cmd.Process)
+			<-sc.exited
+		}
+		killGroup(cmd.Process)
+		leaveGroup(cmd.Process)
+		sidecarsMu.Lock()
+		sc.cmd = nil
+		sidecarsMu.Unlock()
+	}
+}
+
+// killSidecars kills the running sidecars without waiting.
+func killSidecars() {
+	sidecarsMu.Lock()
+	defer sidecarsMu.Unlock()
+	for _, sc := range sidecars {
+		if sc.cmd != nil {
+			sc.stopping = true
+			killGroup(sc.cmd.Process)
+		}
+	}
+}
+
+// namedOutput passes the output of a sidecar or builder through like the
+// program's, under its name, so that it can be muted and streamed. Only the
+// program's own output is captured for rerun search.
+func namedOutput(out *os.File, name, source string) *lineWriter {
+	return &lineWriter{out: out, process: name, prefix: outputPrefix(name), line: func(line string) {
+		logs.add(logLine{Process: name, Source: source, Line: line})
+	}}
+}
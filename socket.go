@@ -0,0 +1,132 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	listen_addrs stringList
+	handoff      = flag.Bool("handoff", false, "With --listen, start each new build on the sockets before stopping the old one, so no connection is dropped")
+	handoff_wait = flag.Duration("handoff-wait", time.Second, "With --handoff and no --ready-regex, how long a new build has to stay up before the old one is stopped")
+)
+
+func init() {
+	flag.Var(&listen_addrs, "listen", "Open this socket once and hand it to every run of the program the systemd way (LISTEN_FDS), as host:port or unix:path (repeatable)")
+}
+
+// openSockets opens the --listen sockets. rerun keeps them open for the whole
+// session, so connections made while the program restarts wait in the
+// backlog instead of being refused.
+func openSockets() (files []*os.File, err error) {
+	for _, addr := range listen_addrs {
+		network := "tcp"
+		if strings.HasPrefix(addr, "unix:") {
+			network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+			if fi, serr := os.Stat(addr); serr == nil && fi.Mode()&os.ModeSocket != 0 {
+				// left behind by an earlier session.
+				os.Remove(addr)
+			}
+		}
+		var l net.Listener
+		l, err = net.Listen(network, addr)
+		if err != nil {
+			return
+		}
+		if network == "unix" {
+			created.add(addr)
+		}
+		var f *os.File
+		switch l := l.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			f, err = l.File()
+		}
+		if err != nil {
+			return
+		}
+		log.Printf("listening on %s %s for the program", network, l.Addr())
+		files = append(files, f)
+	}
+	return
+}
+
+// activate passes the sockets to cmd as LISTEN_FDS expects them: as
+// descriptors 3 and up, with LISTEN_PID set to the pid of the process that
+// gets them. That pid is only known once it runs, so a shell sets it before
+// exec'ing the actual command.
+func (s *session) activate(cmd *exec.Cmd) {
+	if len(s.sockets) == 0 {
+		return
+	}
+	if runtime.GOOS == "windows" || *container_image != "" {
+		log.Printf("--listen sockets cannot be passed to the program here, it has to open its own")
+		return
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		log.Printf("--listen needs sh to start the program: %s", err)
+		return
+	}
+	cmd.ExtraFiles = s.sockets
+	cmd.Env = append(cmd.Env, "LISTEN_FDS="+strconv.Itoa(len(s.sockets)))
+	cmd.Args = append([]string{"sh", "-c", `LISTEN_PID=$$; export LISTEN_PID; exec "$@"`, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+}
+
+// handingOff reports whether the old build keeps serving on the --listen
+// sockets until the new one takes over.
+func (s *session) handingOff() bool {
+	// elsewhere the program opens its own, which the two builds can't share.
+	return *handoff && len(s.sockets) > 0 && runtime.GOOS != "windows" && *container_image == ""
+}
+
+// takeOver waits until the new build c can take the sockets over: once its
+// output matches --ready-regex, if given, or else once it stayed up for
+// --handoff-wait. Both builds accept on the same sockets, so the other
+// probes can't tell them apart.
+func takeOver(c *child, matched <-chan bool) (err error) {
+	if ready_regex.re == nil {
+		select {
+		case <-c.exited:
+			return errors.New("it exited: " + exitDescription(c.err))
+		case <-time.After(*handoff_wait):
+			return nil
+		}
+	}
+	select {
+	case <-matched:
+		return nil
+	case <-c.exited:
+		return errors.New("it exited: " + exitDescription(c.err))
+	case <-time.After(*ready_timeout):
+		return errors.New("no output matching " + strconv.Quote(ready_regex.re.String()) + " after " + ready_timeout.String())
+	}
+}
+
+// abandon gets rid of a new build that didn't take over, and reports
+// whether it had exited already.
+func abandon(c *child) (exited bool) {
+	select {
+	case <-c.exited:
+		killGroup(c.proc)
+		leaveGroup(c.proc)
+		return true
+	default:
+		stop(c)
+		return false
+	}
+}
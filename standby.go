@@ -0,0 +1,121 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	standby_addr    = flag.String("standby", "", "Serve the program through a proxy on this address, keeping the previous build serving until a new one passes its health check; the program must listen on $PORT")
+	standby_health  = flag.String("standby-health", "", "With --standby, the path a new build must answer with a 2xx or 3xx status before it takes over (default: accepting connections)")
+	standby_timeout = flag.Duration("standby-timeout", 30*time.Second, "With --standby, how long a new build gets to pass its health check")
+)
+
+// A standby proxies requests to the build that last passed its health
+// check, which keeps serving while the next one builds and boots.
+type standby struct {
+	mu     sync.Mutex
+	target string // host:port of the serving build, "" before the first
+	build  int
+}
+
+// startStandby listens on addr and proxies what arrives to the serving
+// build.
+func startStandby(addr string) (sb *standby, err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	sb = &standby{}
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = sb.serving()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if sb.serving() == "" {
+				http.Error(w, "rerun: no build of the program is up yet", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "rerun: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	log.Printf("serving the program on http://%s", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, proxy); err != nil {
+			log.Printf("standby proxy: %s", err)
+		}
+	}()
+	return
+}
+
+func (sb *standby) serving() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.target
+}
+
+// promote sends the requests to target, which runs build, from now on.
+func (sb *standby) promote(target string, build int) {
+	sb.mu.Lock()
+	sb.target, sb.build = target, build
+	sb.mu.Unlock()
+}
+
+// freePort finds a port nothing listens on for the next build.
+func (sb *standby) freePort() (port string, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port), nil
+}
+
+// healthy waits for the build c runs to accept connections on target and,
+// with --standby-health, to answer that request well.
+func (sb *standby) healthy(c *child, target string) (err error) {
+	deadline := time.Now().Add(*standby_timeout)
+	client := &http.Client{Timeout: time.Second}
+	for {
+		select {
+		case <-c.exited:
+			return errors.New("it exited: " + exitDescription(c.err))
+		default:
+		}
+		if *standby_health == "" {
+			conn, derr := net.DialTimeout("tcp", target, time.Second)
+			if derr == nil {
+				conn.Close()
+				return nil
+			}
+			err = derr
+		} else {
+			resp, gerr := client.Get("http://" + target + *standby_health)
+			if gerr == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 400 {
+					return nil
+				}
+				gerr = fmt.Errorf("GET %s: %s", *standby_health, resp.Status)
+			}
+			err = gerr
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not healthy after %s: %s", *standby_timeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
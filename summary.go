@@ -0,0 +1,73 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+var exit_hook = flag.String("exit-hook", "", "Shell command run when rerun shuts down, with a JSON summary of the session on stdin")
+
+// sessionSummary is what the exit hook gets to see.
+type sessionSummary struct {
+	Buildpath string         `json:"buildpath"`
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	Duration  time.Duration  `json:"duration"`
+	Cycles    int            `json:"cycles"`
+	Failures  int            `json:"failures"`
+	Restarts  int            `json:"restarts"`
+	Results   map[string]int `json:"results"`
+	Flaky     []string       `json:"flaky,omitempty"`
+	Latency   *latencyReport `json:"latency,omitempty"`
+	CycleTime struct {
+		Total time.Duration `json:"total"`
+		Mean  time.Duration `json:"mean"`
+		Max   time.Duration `json:"max"`
+	} `json:"cycle_time"`
+}
+
+func newSummary(buildpath string) *sessionSummary {
+	return &sessionSummary{Buildpath: buildpath, Start: time.Now(), Results: map[string]int{}}
+}
+
+// add counts a finished cycle.
+func (sum *sessionSummary) add(rec *cycleRecord) {
+	sum.Cycles++
+	sum.Results[rec.Result]++
+	if rec.Result != "ok" {
+		sum.Failures++
+	}
+	sum.CycleTime.Total += rec.Duration
+	sum.CycleTime.Mean = sum.CycleTime.Total / time.Duration(sum.Cycles)
+	if rec.Duration > sum.CycleTime.Max {
+		sum.CycleTime.Max = rec.Duration
+	}
+}
+
+// runExitHook hands the summary to --exit-hook.
+func (s *session) runExitHook() {
+	s.summary.End = time.Now()
+	s.summary.Duration = s.summary.End.Sub(s.summary.Start)
+	s.summary.Flaky = s.flakes.list()
+	s.summary.Latency = s.latency.report()
+	data, err := json.MarshalIndent(s.summary, "", "  ")
+	if err != nil {
+		return
+	}
+	cmd := shellCommand(*exit_hook)
+	cmd.Dir = s.dir
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("exit hook: %s", err)
+	}
+}
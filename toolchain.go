@@ -0,0 +1,160 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var toolchain = flag.String("toolchain", "", "Build with this go toolchain, e.g. go1.22.3, downloading it if needed; go.mod uses the one go.mod asks for")
+
+// pinned is the toolchain builds run with, or "" to leave it to the go
+// command.
+var pinned string
+
+// modToolchain reads the toolchain go.mod above dir asks for: its toolchain
+// directive or, failing that, its go directive.
+func modToolchain(dir string) (name string) {
+	toolchain, goVersion := modVersions(dir)
+	if toolchain != "" {
+		return toolchain
+	}
+	return goVersion
+}
+
+// modVersions reads the toolchain and go directives of the go.mod above dir,
+// the latter as a toolchain name such as go1.22.0.
+func modVersions(dir string) (toolchain, goVersion string) {
+	for {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) != 2 {
+					continue
+				}
+				switch fields[0] {
+				case "toolchain":
+					toolchain = fields[1]
+				case "go":
+					goVersion = goDirectiveToolchain(fields[1])
+				}
+			}
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// goDirectiveToolchain names the toolchain a go directive asks for. From
+// go1.21 on, "go 1.22" means the language version, whose first release is
+// go1.22.0; there is no toolchain called go1.22.
+func goDirectiveToolchain(v string) string {
+	p := versionParts(v)
+	plain := strings.Trim(v, "0123456789.") == ""
+	if plain && strings.Count(v, ".") == 1 && (p[0] > 1 || p[0] == 1 && p[1] >= 21) {
+		return "go" + v + ".0"
+	}
+	return "go" + v
+}
+
+// pinToolchain decides which toolchain builds of the package in dir use.
+func pinToolchain(dir string) {
+	switch *toolchain {
+	case "":
+		return
+	case "go.mod":
+		pinned = modToolchain(dir)
+		if pinned == "" {
+			log.Printf("--toolchain=go.mod: no go.mod above %s, using the default toolchain", dir)
+			return
+		}
+	default:
+		pinned = *toolchain
+	}
+}
+
+// toolchainEnv makes the go command switch to the pinned toolchain.
+func toolchainEnv(env []string) []string {
+	if pinned == "" {
+		return env
+	}
+	return append(env, "GOTOOLCHAIN="+pinned)
+}
+
+// checkToolchain makes sure the go command runs and can build the package in
+// dir, and returns the version of the toolchain it builds with. Its errors say
+// what to do about the problem.
+func checkToolchain(dir string) (version string, err error) {
+	if *dev_env == "" {
+		if _, lerr := exec.LookPath("go"); lerr != nil {
+			return "", errors.New("the go command is not on PATH: install Go from https://go.dev/dl/ or add its bin directory to PATH")
+		}
+	}
+	cmd := command("go", "env", "GOVERSION", "GOROOT", "GOPATH", "GOTOOLCHAIN")
+	cmd.Env = installEnv()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("the go command does not run (%s): %s", err, strings.TrimSpace(stderr.String()))
+	}
+	vars := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for len(vars) < 4 {
+		vars = append(vars, "")
+	}
+	version, goroot, gopath, gotoolchain := vars[0], vars[1], vars[2], vars[3]
+	if version == "" {
+		return "", errors.New("the go command is older than go1.16: install a recent Go from https://go.dev/dl/")
+	}
+	if _, serr := os.Stat(filepath.Join(goroot, "src", "runtime")); serr != nil {
+		return "", fmt.Errorf("GOROOT %s is not a Go installation: unset GOROOT, or point it at the directory Go is installed in", goroot)
+	}
+	if gopath != "" && filepath.Clean(gopath) == filepath.Clean(goroot) {
+		return "", fmt.Errorf("GOPATH and GOROOT are both %s: unset GOPATH, or point it at a workspace of your own", goroot)
+	}
+	if _, want := modVersions(dir); want != "" && gotoolchain == "local" && versionLess(version, want) {
+		return "", fmt.Errorf("go.mod asks for %s but %s is installed and GOTOOLCHAIN=local: install %s, or let the go command fetch it with GOTOOLCHAIN=auto", want, version, want)
+	}
+	return
+}
+
+// versionLess reports whether toolchain a, like go1.21.3, is older than b.
+// Prereleases count as their release.
+func versionLess(a, b string) bool {
+	va, vb := versionParts(a), versionParts(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return false
+}
+
+func versionParts(v string) (parts [3]int) {
+	v = strings.TrimPrefix(v, "go")
+	if i := strings.IndexAny(v, "abcdefghijklmnopqrstuvwxyz -"); i >= 0 {
+		v = v[:i]
+	}
+	for i, p := range strings.SplitN(v, ".", 3) {
+		parts[i], _ = strconv.Atoi(p)
+	}
+	return
+}
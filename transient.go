@@ -0,0 +1,42 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"regexp"
+	"time"
+)
+
+var (
+	transient_retries = flag.Int("transient-retries", 3, "How often a build that failed for a passing reason, such as a module proxy timeout or a locked file, is retried before it counts as failed")
+	transient_backoff = flag.Duration("transient-backoff", time.Second, "How long to wait before the first retry of a build that failed for a passing reason; the wait doubles with every retry")
+)
+
+// transientErrors are build failures that say nothing about the code: the
+// network, the module proxy or another process got in the way.
+var transientErrors = []*regexp.Regexp{
+	regexp.MustCompile(`i/o timeout`),
+	regexp.MustCompile(`TLS handshake timeout`),
+	regexp.MustCompile(`connection reset by peer`),
+	regexp.MustCompile(`dial tcp .*: connect: connection refused`),
+	regexp.MustCompile(`[Tt]emporary failure in name resolution`),
+	regexp.MustCompile(`(reading|fetching) https?://\S+: (429|50[0234])`),
+	regexp.MustCompile(`text file busy`),
+	regexp.MustCompile(`resource temporarily unavailable`),
+	regexp.MustCompile(`being used by another process`),
+	regexp.MustCompile(`[Tt]he process cannot access the file`),
+}
+
+// transient reports why output, that of a failed build, looks like a
+// passing failure, or "" if it doesn't.
+func transient(output string) (reason string) {
+	for _, re := range transientErrors {
+		if m := re.FindString(output); m != "" {
+			return m
+		}
+	}
+	return ""
+}
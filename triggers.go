@@ -0,0 +1,254 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// triggerCooldown keeps a trigger from firing in a loop, say when freeing a
+// port doesn't help.
+const triggerCooldown = 5 * time.Second
+
+// A trigger takes an action when a line of the program's output matches.
+// Action is restart, notify, free-port or exec:<shell command>.
+type trigger struct {
+	Label   string
+	Pattern *regexp.Regexp
+	Action  string
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// triggerList is a repeatable "regex=>action" flag.
+type triggerList []*trigger
+
+func (l *triggerList) String() string {
+	var parts []string
+	for _, t := range *l {
+		part := t.Pattern.String() + "=>" + t.Action
+		if t.Label != "" {
+			part = "@" + t.Label + " " + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *triggerList) Set(value string) error {
+	label, value := labelled(value)
+	i := strings.LastIndex(value, "=>")
+	if i < 0 {
+		return fmt.Errorf("expected regex=>action, got %q", value)
+	}
+	re, err := regexp.Compile(value[:i])
+	if err != nil {
+		return err
+	}
+	action := strings.TrimSpace(value[i+2:])
+	switch {
+	case action == "restart", action == "notify", action == "free-port":
+	case strings.HasPrefix(action, "exec:"):
+	default:
+		return fmt.Errorf("unknown action %q (want restart, notify, free-port or exec:command)", action)
+	}
+	*l = append(*l, &trigger{Label: label, Pattern: re, Action: action})
+	return nil
+}
+
+var triggers triggerList
+
+func init() {
+	flag.Var(&triggers, "on", "Act on lines of the program's output matching a regex, as [@label ]regex=>action where action is restart, notify, free-port or exec:command (repeatable)")
+}
+
+// lineWriter passes writes through to out and hands every complete line
+// to line. The output of a process, named by process, goes through the
+// output view: while it filters, out only gets the lines it shows.
+type lineWriter struct {
+	out     io.Writer
+	line    func(string)
+	process string
+	prefix  string // written before every line
+	buf     []byte
+}
+
+// maxLine bounds the buffer when the program writes without newlines.
+const maxLine = 64 << 10
+
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	// prefixed output goes out a line at a time.
+	filtered := w.process != "" && (view.active() || w.prefix != "" || *timestamps)
+	if filtered {
+		n = len(p)
+	} else {
+		n, err = w.out.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf[:i])
+		if filtered && view.shows(w.process, line) {
+			w.out.Write(append(w.lead(), w.buf[:i+1]...))
+		}
+		w.line(line)
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLine {
+		if filtered && view.shows(w.process, string(w.buf)) {
+			w.out.Write(append(w.lead(), w.buf...))
+		}
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+	return
+}
+
+// lead is what goes before a line: the time, with --timestamps, and the
+// prefix.
+func (w *lineWriter) lead() []byte {
+	if !*timestamps {
+		return []byte(w.prefix)
+	}
+	return []byte(time.Now().Format("15:04:05.000 ") + w.prefix)
+}
+
+func (s *session) matchTriggers(line string) {
+	for _, t := range triggers {
+		if !labels.on(t.Label) || !t.Pattern.MatchString(line) {
+			continue
+		}
+		t.mu.Lock()
+		cooling := time.Since(t.last) < triggerCooldown
+		if !cooling {
+			t.last = time.Now()
+		}
+		t.mu.Unlock()
+		if cooling {
+			continue
+		}
+		log.Printf("%q matched %s, %s", line, t.Pattern, t.Action)
+		go s.act(t, line)
+	}
+}
+
+// act carries out t's action for the matching line.
+func (s *session) act(t *trigger, line string) {
+	switch {
+	case t.Action == "restart":
+		s.restartRequested()
+	case t.Action == "notify":
+		notify(s.binName, line)
+	case t.Action == "free-port":
+		port := portIn(t.Pattern, line)
+		if port == "" {
+			log.Printf("free-port: no port in %q", line)
+			return
+		}
+		freePort(port)
+		s.restartRequested()
+	case strings.HasPrefix(t.Action, "exec:"):
+		cmd := shellCommand(strings.TrimPrefix(t.Action, "exec:"))
+		cmd.Dir = s.dir
+		cmd.Env = append(os.Environ(), "RERUN_LINE="+line)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("%s: %s", t.Action, err)
+		}
+	}
+}
+
+// restartRequested rebuilds and restarts the program even if nothing changed.
+func (s *session) restartRequested() {
+	s.events <- watch.Event{Name: s.binPath, Op: watch.Save, Time: time.Now()}
+}
+
+// cycleRequested runs a cycle even if nothing changed, restarting the
+// program only if its binary did. The package directory stands for no file
+// in particular.
+func (s *session) cycleRequested() {
+	s.events <- watch.Event{Name: s.dir, Op: watch.Save, Time: time.Now()}
+}
+
+// stopRequested stops the program until the next cycle starts it again, and
+// reports whether there was a program to stop.
+func (s *session) stopRequested() bool {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.runch == nil {
+		return false
+	}
+	atomic.StoreInt32(&s.halted, 1)
+	s.runch <- launch{}
+	return true
+}
+
+var portPattern = regexp.MustCompile(`:(\d+)`)
+
+// portIn finds the port in line: the regex's first group if it has one,
+// else the first ":<number>".
+func portIn(re *regexp.Regexp, line string) string {
+	if m := re.FindStringSubmatch(line); len(m) > 1 && m[1] != "" {
+		return m[1]
+	}
+	if m := portPattern.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// freePort kills whatever is listening on port, typically a copy of the
+// program left over from an earlier session.
+func freePort(port string) {
+	if runtime.GOOS == "windows" {
+		log.Printf("free-port: not supported on windows, stop whatever listens on %s by hand", port)
+		return
+	}
+	out, err := exec.Command("lsof", "-t", "-sTCP:LISTEN", "-i", ":"+port).Output()
+	if err != nil {
+		log.Printf("free-port: nothing found listening on %s (%s)", port, err)
+		return
+	}
+	for _, pid := range strings.Fields(string(out)) {
+		log.Printf("killing process %s, listening on port %s", pid, port)
+		exec.Command("kill", pid).Run()
+	}
+}
+
+// notify shows a desktop notification where one is available.
+func notify(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, title))
+	case "windows":
+		log.Printf("%s: %s", title, message)
+		return
+	default:
+		cmd = exec.Command("notify-send", title, message)
+	}
+	if err := cmd.Run(); err != nil {
+		log.Printf("notification failed: %s", err)
+	}
+}
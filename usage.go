@@ -0,0 +1,88 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"log"
+	"sync"
+	"time"
+)
+
+var usage_every = flag.Duration("usage-every", 2*time.Second, "How often to sample the program's memory and CPU use, for the control API's status; 0 turns it off")
+
+// procUsage is the resource use of the running program, along with whatever
+// it started.
+type procUsage struct {
+	PID     int
+	Build   int           `json:",omitempty"`
+	RSS     int64         // resident memory, in bytes
+	PeakRSS int64         // the most RSS seen since it started
+	CPU     float64       // percent of one core since the previous sample
+	CPUTime time.Duration // since it started
+	Sampled time.Time
+}
+
+// A usageMeter keeps the latest sample of the program's use.
+type usageMeter struct {
+	mu  sync.Mutex
+	cur *procUsage
+}
+
+func (m *usageMeter) get() *procUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cur == nil {
+		return nil
+	}
+	u := *m.cur
+	return &u
+}
+
+// watch samples the process group of pid until exited is closed, then logs
+// how much the run used.
+func (m *usageMeter) watch(name string, pid, build int, exited <-chan bool) {
+	if *usage_every <= 0 {
+		return
+	}
+	u := procUsage{PID: pid, Build: build}
+	ticker := time.NewTicker(*usage_every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exited:
+			m.mu.Lock()
+			if m.cur != nil && m.cur.PID == pid {
+				m.cur = nil
+			}
+			m.mu.Unlock()
+			if !u.Sampled.IsZero() {
+				log.Printf("%s (build %d) used at most %.1f MB of memory and %s of CPU", name, build, float64(u.PeakRSS)/(1<<20), u.CPUTime.Round(10*time.Millisecond))
+			}
+			return
+		case <-ticker.C:
+		}
+		if idle.isIdle() {
+			continue
+		}
+		rss, cpu, err := groupUsage(pid)
+		if err != nil {
+			// unsupported here, or it is on its way out.
+			continue
+		}
+		now := time.Now()
+		if !u.Sampled.IsZero() {
+			u.CPU = 100 * float64(cpu-u.CPUTime) / float64(now.Sub(u.Sampled))
+		}
+		u.RSS, u.CPUTime, u.Sampled = rss, cpu, now
+		if rss > u.PeakRSS {
+			u.PeakRSS = rss
+		}
+		sample := u
+		m.mu.Lock()
+		m.cur = &sample
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, what /proc counts CPU time in.
+const clockTicks = 100
+
+// groupUsage adds up the resident memory and CPU time of the processes in
+// the process group pgid.
+func groupUsage(pgid int) (rss int64, cpu time.Duration, err error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return
+	}
+	found := false
+	for _, name := range stats {
+		data, rerr := ioutil.ReadFile(name)
+		if rerr != nil {
+			continue
+		}
+		// the command name, in parentheses, may hold spaces.
+		i := strings.LastIndexByte(string(data), ')')
+		if i < 0 {
+			continue
+		}
+		f := strings.Fields(string(data[i+1:]))
+		if len(f) < 22 || f[2] != strconv.Itoa(pgid) {
+			continue
+		}
+		found = true
+		utime, _ := strconv.ParseInt(f[11], 10, 64)
+		stime, _ := strconv.ParseInt(f[12], 10, 64)
+		pages, _ := strconv.ParseInt(f[21], 10, 64)
+		cpu += time.Duration(utime+stime) * time.Second / clockTicks
+		rss += pages * int64(os.Getpagesize())
+	}
+	if !found {
+		err = os.ErrNotExist
+	}
+	return
+}
@@ -0,0 +1,54 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groupUsage adds up the resident memory and CPU time of the processes in
+// the process group pgid, as ps reports them.
+func groupUsage(pgid int) (rss int64, cpu time.Duration, err error) {
+	out, err := exec.Command("ps", "-A", "-o", "pgid=,rss=,time=").Output()
+	if err != nil {
+		return
+	}
+	found := false
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != strconv.Itoa(pgid) {
+			continue
+		}
+		found = true
+		kb, _ := strconv.ParseInt(f[1], 10, 64)
+		rss += kb << 10
+		cpu += psTime(f[2])
+	}
+	if !found {
+		err = os.ErrNotExist
+	}
+	return
+}
+
+// psTime reads ps's [[dd-]hh:]mm:ss[.cc] CPU time.
+func psTime(s string) (d time.Duration) {
+	if i := strings.Index(s, "-"); i >= 0 {
+		days, _ := strconv.Atoi(s[:i])
+		d += time.Duration(days) * 24 * time.Hour
+		s = s[i+1:]
+	}
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		v, _ := strconv.ParseFloat(part, 64)
+		secs = secs*60 + v
+	}
+	return d + time.Duration(secs*float64(time.Second))
+}
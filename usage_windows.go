@@ -0,0 +1,15 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// groupUsage is not sampled on windows.
+func groupUsage(pgid int) (rss int64, cpu time.Duration, err error) {
+	return 0, 0, errors.New("not supported on windows")
+}
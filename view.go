@@ -0,0 +1,108 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// outputView decides which processes' output reaches the terminal: muted
+// processes are hidden, a soloed process is the only one shown, and a
+// filter only lets matching lines through.
+type outputView struct {
+	mu     sync.Mutex
+	muted  map[string]bool
+	solo   string
+	filter *regexp.Regexp
+}
+
+var view = &outputView{muted: map[string]bool{}}
+
+// viewState is what the control API reports about the view.
+type viewState struct {
+	Muted  []string
+	Solo   string
+	Filter string
+}
+
+func (v *outputView) active() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.muted) > 0 || v.solo != "" || v.filter != nil
+}
+
+func (v *outputView) shows(process, line string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.muted[process] || (v.solo != "" && v.solo != process) {
+		return false
+	}
+	return v.filter == nil || v.filter.MatchString(line)
+}
+
+func (v *outputView) state() (st viewState) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for name := range v.muted {
+		st.Muted = append(st.Muted, name)
+	}
+	sort.Strings(st.Muted)
+	st.Solo = v.solo
+	if v.filter != nil {
+		st.Filter = v.filter.String()
+	}
+	return
+}
+
+// serveView handles the view's part of the control API:
+//
+//	GET /output	the current view
+//	POST /output/mute?process=name	hide a process's output
+//	POST /output/unmute?process=name	show it again
+//	POST /output/solo?process=name	show only this process, or everything with no name
+//	POST /output/filter?re=regex	show only matching lines, or everything with no regex
+func serveView(mux *http.ServeMux) {
+	mux.HandleFunc("/output", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, view.state())
+	})
+	change := func(path string, f func(r *http.Request) error) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			view.mu.Lock()
+			err := f(r)
+			view.mu.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, view.state())
+		})
+	}
+	change("/output/mute", func(r *http.Request) error {
+		view.muted[r.FormValue("process")] = true
+		return nil
+	})
+	change("/output/unmute", func(r *http.Request) error {
+		delete(view.muted, r.FormValue("process"))
+		return nil
+	})
+	change("/output/solo", func(r *http.Request) error {
+		view.solo = r.FormValue("process")
+		return nil
+	})
+	change("/output/filter", func(r *http.Request) (err error) {
+		view.filter = nil
+		if re := r.FormValue("re"); re != "" {
+			view.filter, err = regexp.Compile(re)
+		}
+		return
+	})
+}
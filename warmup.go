@@ -0,0 +1,119 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	warmup_request = flag.String("warmup", "", "Send this request to the program after every restart, as [METHOD ]url")
+	replay_count   = flag.Int("replay", 0, "With --proxy, remember the last this many GET and HEAD requests and replay them against the program after every restart")
+)
+
+// warmupTimeout is how long the program gets to start accepting requests.
+const warmupTimeout = 10 * time.Second
+
+// warmUp sends the --warmup request once the new program accepts it, so
+// that the first real request doesn't pay for cold caches.
+func warmUp() (ok bool) {
+	method, url := "GET", *warmup_request
+	if i := strings.Index(url, " "); i > 0 {
+		method, url = strings.ToUpper(url[:i]), strings.TrimSpace(url[i+1:])
+	}
+	client := &http.Client{Timeout: warmupTimeout}
+	deadline := time.Now().Add(warmupTimeout)
+	for {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			log.Printf("warm-up: %s", err)
+			return
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			log.Printf("warm-up %s %s: %s in %s", method, url, resp.Status, time.Since(start).Round(time.Millisecond))
+			return true
+		}
+		if time.Now().After(deadline) {
+			log.Printf("warm-up %s %s: %s", method, url, err)
+			return
+		}
+		// most likely the program isn't listening yet.
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// A recordedRequest is a request that went through the proxy, as it was
+// passed on to the program.
+type recordedRequest struct {
+	method string
+	url    string
+	header http.Header
+}
+
+// replayRing keeps the last --replay idempotent requests through the proxy.
+type replayRing struct {
+	mu   sync.Mutex
+	reqs []recordedRequest
+}
+
+func (rr *replayRing) record(r *http.Request) {
+	if *replay_count <= 0 || (r.Method != "GET" && r.Method != "HEAD") {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.reqs = append(rr.reqs, recordedRequest{method: r.Method, url: r.URL.String(), header: r.Header.Clone()})
+	if len(rr.reqs) > *replay_count {
+		rr.reqs = rr.reqs[len(rr.reqs)-*replay_count:]
+	}
+}
+
+// replay sends the recorded requests to the new program, one after the
+// other, so that what was used last is warm again.
+func (rr *replayRing) replay() {
+	rr.mu.Lock()
+	reqs := append([]recordedRequest{}, rr.reqs...)
+	rr.mu.Unlock()
+	client := &http.Client{
+		Timeout: warmupTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// the program may not be listening yet.
+	deadline := time.Now().Add(warmupTimeout)
+	for _, rec := range reqs {
+		req, err := http.NewRequest(rec.method, rec.url, nil)
+		if err != nil {
+			continue
+		}
+		req.Header = rec.header
+		start := time.Now()
+		resp, err := client.Do(req)
+		for err != nil && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+			start = time.Now()
+			resp, err = client.Do(req)
+		}
+		if err != nil {
+			log.Printf("replay %s %s: %s", rec.method, rec.url, err)
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("replayed %s %s: %s in %s", rec.method, rec.url, resp.Status, time.Since(start).Round(time.Millisecond))
+	}
+}
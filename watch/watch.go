@@ -0,0 +1,323 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package watch decides when a stream of file system events should trigger a
+// rebuild. Tools embedding rerun's loop can supply their own EventFilter and
+// Debouncer instead of reimplementing the watcher.
+package watch
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Op describes what happened to a file.
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+	Chmod
+	// Save is an explicit notification, e.g. from an editor, that a file
+	// was saved. It does not come from the file system.
+	Save
+)
+
+// An Event is a single change to a watched file.
+type Event struct {
+	Name string
+	Op   Op
+	Time time.Time
+}
+
+// An EventFilter decides whether an event is relevant at all.
+type EventFilter interface {
+	Keep(ctx context.Context, ev Event) bool
+}
+
+// FilterFunc adapts an ordinary function to the EventFilter interface.
+type FilterFunc func(ctx context.Context, ev Event) bool
+
+func (f FilterFunc) Keep(ctx context.Context, ev Event) bool {
+	return f(ctx, ev)
+}
+
+// A Debouncer groups relevant events into batches, each of which triggers one
+// rebuild. It must close the returned channel once in is closed or ctx is
+// done.
+type Debouncer interface {
+	Debounce(ctx context.Context, in <-chan Event) <-chan []Event
+}
+
+// Pipeline filters the events arriving on in and hands the survivors to d.
+func Pipeline(ctx context.Context, in <-chan Event, filter EventFilter, d Debouncer) <-chan []Event {
+	kept := make(chan Event)
+	go func() {
+		defer close(kept)
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					return
+				}
+				if filter != nil && !filter.Keep(ctx, ev) {
+					continue
+				}
+				select {
+				case kept <- ev:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return d.Debounce(ctx, kept)
+}
+
+// Ext keeps events for files with one of the given extensions, e.g. ".go".
+func Ext(exts ...string) EventFilter {
+	return FilterFunc(func(ctx context.Context, ev Event) bool {
+		ext := filepath.Ext(ev.Name)
+		for _, e := range exts {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Ops keeps events whose Op is in mask.
+func Ops(mask Op) EventFilter {
+	return FilterFunc(func(ctx context.Context, ev Event) bool {
+		return ev.Op&mask != 0
+	})
+}
+
+// Any keeps events that at least one of filters keeps.
+func Any(filters ...EventFilter) EventFilter {
+	return FilterFunc(func(ctx context.Context, ev Event) bool {
+		for _, f := range filters {
+			if f.Keep(ctx, ev) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// All keeps events that every one of filters keeps.
+func All(filters ...EventFilter) EventFilter {
+	return FilterFunc(func(ctx context.Context, ev Event) bool {
+		for _, f := range filters {
+			if !f.Keep(ctx, ev) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Quiet returns a Debouncer that emits a batch once no event has arrived for
+// the given duration.
+func Quiet(quiet time.Duration) Debouncer {
+	return Windows(quiet)
+}
+
+// A Window sets the debounce interval for events on files matching Pattern
+// (see Match).
+type Window struct {
+	Pattern string
+	Quiet   time.Duration
+}
+
+// Windows returns a Debouncer that works like Quiet(def), except that events
+// on files matching one of windows wait for that window's interval instead.
+// The first matching window wins. A batch is emitted once every event in it
+// has been quiet for its own interval, so a zero window flushes immediately.
+func Windows(def time.Duration, windows ...Window) Debouncer {
+	return &windowDebouncer{def: def, windows: windows}
+}
+
+type windowDebouncer struct {
+	def     time.Duration
+	windows []Window
+}
+
+func (w *windowDebouncer) quiet(name string) time.Duration {
+	for _, win := range w.windows {
+		if Match(win.Pattern, name) {
+			return win.Quiet
+		}
+	}
+	return w.def
+}
+
+func (w *windowDebouncer) Debounce(ctx context.Context, in <-chan Event) <-chan []Event {
+	out := make(chan []Event)
+	go func() {
+		defer close(out)
+		var batch []Event
+		var deadline time.Time
+		var timer *time.Timer
+		var fire <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					return
+				}
+				batch = append(batch, ev)
+				quiet := w.quiet(ev.Name)
+				if d := time.Now().Add(quiet); quiet == 0 || d.After(deadline) {
+					if quiet == 0 {
+						d = time.Now()
+					}
+					deadline = d
+					if timer != nil {
+						timer.Stop()
+					}
+					timer = time.NewTimer(time.Until(deadline))
+					fire = timer.C
+				}
+			case <-fire:
+				select {
+				case out <- batch:
+				case <-ctx.Done():
+					return
+				}
+				batch, deadline, timer, fire = nil, time.Time{}, nil, nil
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Match reports whether the file name matches pattern. A pattern without a
+// slash is matched against the base name ("*.go"); one with a slash is matched
+// against the trailing elements of the path ("gen/*.go").
+func Match(pattern, name string) bool {
+	name = filepath.ToSlash(name)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	n := strings.Count(pattern, "/") + 1
+	parts := strings.Split(name, "/")
+	if len(parts) < n {
+		return false
+	}
+	ok, _ := path.Match(pattern, strings.Join(parts[len(parts)-n:], "/"))
+	return ok
+}
+
+// Glob reports whether the slash separated name matches pattern, where a "**"
+// element matches any number of path elements, including none, and every other
+// element is matched as by path.Match: "**/testdata/**", "gen/**/*.pb.go".
+func Glob(pattern, name string) bool {
+	return globParts(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(name), "/"))
+}
+
+func globParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if globParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// A Burst says when a run of changes, such as a code generator rewriting many
+// files, counts as a burst: when one batch holds at least Size events, or Rate
+// events arrive within a second. Zero turns either test off. A burst is over
+// once no event has arrived for Quiet.
+type Burst struct {
+	Size  int
+	Rate  int
+	Quiet time.Duration
+}
+
+// Bursts wraps d so that the batches of a burst are held back and emitted as
+// one once the burst is over, rather than as they come.
+func Bursts(d Debouncer, b Burst) Debouncer {
+	return &bursts{d: d, b: b}
+}
+
+type bursts struct {
+	d Debouncer
+	b Burst
+}
+
+// starts reports whether batch, with recent the times of the events of the
+// last second, starts a burst.
+func (b *bursts) starts(batch []Event, recent []time.Time) bool {
+	return (b.b.Size > 0 && len(batch) >= b.b.Size) || (b.b.Rate > 0 && len(recent) >= b.b.Rate)
+}
+
+func (b *bursts) Debounce(ctx context.Context, in <-chan Event) <-chan []Event {
+	batches := b.d.Debounce(ctx, in)
+	out := make(chan []Event)
+	go func() {
+		defer close(out)
+		var recent []time.Time
+		var burst, ready []Event
+		var over <-chan time.Time
+		for {
+			var send chan<- []Event
+			if ready != nil {
+				send = out
+			}
+			select {
+			case batch, ok := <-batches:
+				if !ok {
+					return
+				}
+				since := time.Now().Add(-time.Second)
+				for len(recent) > 0 && recent[0].Before(since) {
+					recent = recent[1:]
+				}
+				for _, ev := range batch {
+					if ev.Time.After(since) {
+						recent = append(recent, ev.Time)
+					}
+				}
+				if burst == nil && !b.starts(batch, recent) {
+					ready = append(ready, batch...)
+					continue
+				}
+				burst = append(burst, batch...)
+				over = time.After(b.b.Quiet)
+			case <-over:
+				ready = append(ready, burst...)
+				burst, over = nil, nil
+			case send <- ready:
+				ready = nil
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
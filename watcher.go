@@ -0,0 +1,305 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"go/build"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/howeyc/fsnotify"
+	"github.com/skelterjohn/rerun/watch"
+)
+
+// depGraph remembers the directory and imports of every package seen while
+// scanning, keyed by import path.
+type depGraph map[string]*build.Package
+
+// getWatcher starts delivering events for the directories of the session's
+// package and its dependencies, either from a local watcher or from the
+// shared daemon. Closing the result stops the events.
+func (s *session) getWatcher(events chan<- watch.Event) (watcher io.Closer, err error) {
+	if *follow_addr != "" {
+		// the leader watches.
+		return closers{}, nil
+	}
+	if *daemon_addr != "" {
+		if !noBuild() {
+			// the daemon scans for itself; scanning here too finds the cgo
+			// directories, whose native sources the filter lets through.
+			scanDirs(s.buildpath, s.graph)
+		}
+		extra := s.extraDirs()
+		buildpath := s.buildpath
+		if noBuild() {
+			// there are no packages to scan.
+			buildpath = ""
+		}
+		dw, derr := dialDaemon(*daemon_addr, buildpath, extra, events)
+		if derr != nil {
+			return nil, derr
+		}
+		return dw, nil
+	}
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	var polled []string
+	dirs := s.watchDirs()
+	s.watching = map[string]bool{}
+	for _, dir := range dirs {
+		s.watching[dir] = true
+		if needsPolling(dir) {
+			polled = append(polled, dir)
+			continue
+		}
+		fw.Watch(dir)
+	}
+	watchedDirs.Set(int64(len(dirs) - len(polled)))
+	polledDirs.Set(int64(len(polled)))
+	go forward(fw, events)
+	if len(polled) == 0 {
+		return fw, nil
+	}
+	warnPolling(polled)
+	return closers{fw, newPoller(polled, events)}, nil
+}
+
+// rewatch brings the watches up to date with what the program imports after
+// a successful install: the directories of newly imported packages are
+// watched, and those of packages no longer imported are dropped.
+func (s *session) rewatch(watcher io.Closer, events chan<- watch.Event) (io.Closer, error) {
+	fw, ok := watcher.(*fsnotify.Watcher)
+	if !ok {
+		// the daemon does its own scanning, and polled directories can't be
+		// changed in place; start over.
+		watcher.Close()
+		return s.getWatcher(events)
+	}
+	now := map[string]bool{}
+	for _, dir := range s.watchDirs() {
+		if needsPolling(dir) {
+			fw.Close()
+			return s.getWatcher(events)
+		}
+		now[dir] = true
+	}
+	var added, dropped []string
+	for dir := range now {
+		if !s.watching[dir] {
+			fw.Watch(dir)
+			added = append(added, dir)
+		}
+	}
+	for dir := range s.watching {
+		if !now[dir] {
+			fw.RemoveWatch(dir)
+			dropped = append(dropped, dir)
+		}
+	}
+	s.watching = now
+	watchedDirs.Set(int64(len(now)))
+	if len(added) > 0 {
+		sort.Strings(added)
+		log.Printf("now watching %s", strings.Join(added, ", "))
+	}
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		log.Printf("no longer watching %s", strings.Join(dropped, ", "))
+	}
+	return fw, nil
+}
+
+// watchDirs are the directories to watch: those of the package and its
+// dependencies, and the extra ones.
+func (s *session) watchDirs() (dirs []string) {
+	var all []string
+	if !noBuild() {
+		all = scanDirs(s.buildpath, s.graph)
+	}
+	seen := map[string]bool{}
+	for _, dir := range append(all, s.extraDirs()...) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return
+}
+
+// extraDirs are directories that matter even though no package lives there.
+func (s *session) extraDirs() (dirs []string) {
+	if root := devEnvRoot(); root != "" {
+		dirs = append(dirs, root)
+	}
+	dirs = append(dirs, assetDirs()...)
+	dirs = append(dirs, execDirs()...)
+	dirs = append(dirs, s.protoDirs()...)
+	dirs = append(dirs, s.extDirs()...)
+	dirs = append(dirs, builderDirs()...)
+	dirs = append(dirs, cgoDirList()...)
+	for _, p := range s.watchedPaths() {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			dirs = append(dirs, p)
+		} else {
+			dirs = append(dirs, filepath.Dir(p))
+		}
+	}
+	return
+}
+
+// watchedPaths are the files and directories given with --watch, the
+// --env-file files, and the supervised program itself in --prebuilt mode.
+func (s *session) watchedPaths() (paths []string) {
+	for _, p := range append(append([]string{}, watch_paths...), env_files...) {
+		if abs, err := filepath.Abs(p); err == nil {
+			paths = append(paths, abs)
+		}
+	}
+	if *prebuilt {
+		paths = append(paths, s.binPath)
+	}
+	return
+}
+
+// watchedFilter keeps changes to the watched files, to any file directly
+// inside a watched directory, and to the static assets.
+func (s *session) watchedFilter() watch.EventFilter {
+	paths := s.watchedPaths()
+	return watch.FilterFunc(func(ctx context.Context, ev watch.Event) bool {
+		for _, p := range paths {
+			if ev.Name == p || filepath.Dir(ev.Name) == p {
+				return true
+			}
+		}
+		return isAsset(ev.Name)
+	})
+}
+
+var warnedPolling = map[string]bool{}
+
+// warnPolling explains, once per directory, why it is polled.
+func warnPolling(dirs []string) {
+	for _, dir := range dirs {
+		if !warnedPolling[dir] {
+			warnedPolling[dir] = true
+			log.Printf("%s is on a Windows drive under WSL, where inotify is unreliable; polling it every %s", dir, pollInterval)
+		}
+	}
+}
+
+// closers closes several things as one.
+type closers []io.Closer
+
+func (cs closers) Close() (err error) {
+	for _, c := range cs {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// forward translates the watcher's events until it is closed. The errors are
+// not needed, but they are read to avoid a deadlock.
+func forward(watcher *fsnotify.Watcher, events chan<- watch.Event) {
+	wevents, werrors := watcher.Event, watcher.Error
+	for wevents != nil || werrors != nil {
+		select {
+		case we, ok := <-wevents:
+			if !ok {
+				wevents = nil
+				continue
+			}
+			events <- translate(we)
+		case _, ok := <-werrors:
+			if !ok {
+				werrors = nil
+			}
+		}
+	}
+}
+
+func translate(we *fsnotify.FileEvent) watch.Event {
+	ev := watch.Event{Name: we.Name, Time: time.Now()}
+	switch {
+	case we.IsCreate():
+		ev.Op = watch.Create
+	case we.IsDelete():
+		ev.Op = watch.Remove
+	case we.IsRename():
+		ev.Op = watch.Rename
+	case we.IsAttrib():
+		ev.Op = watch.Chmod
+	default:
+		ev.Op = watch.Write
+	}
+	return ev
+}
+
+// scanDirs returns the directory of importpath and those of its non-GOROOT
+// dependencies that can change, including the local include directories of
+// cgo packages. It asks go list, and walks the imports with go/build itself
+// only if that fails.
+func scanDirs(importpath string, graph depGraph) (dirs []string) {
+	if dirs, ok := listDirs(importpath, graph); ok {
+		return dirs
+	}
+	addDirs(importpath, map[string]bool{}, graph, &dirs)
+	return
+}
+
+// addDirs adds the directory of importpath and, recursively, those of its
+// non-GOROOT imports. A package that does not parse right now (say, in the
+// middle of a file rename) keeps the place it had in the last scan, so that
+// fixing it is noticed.
+func addDirs(importpath string, watching map[string]bool, graph depGraph, dirs *[]string) {
+	pkg, err := build.Import(importpath, "", 0)
+	if err != nil {
+		if last, ok := graph[importpath]; ok {
+			log.Printf("%s: %s, watching its last known state", importpath, err)
+			pkg = last
+		} else if pkg == nil || pkg.Dir == "" {
+			return
+		}
+	} else {
+		graph[importpath] = pkg
+	}
+	if pkg.Goroot {
+		return
+	}
+	*dirs = append(*dirs, pkg.Dir)
+	if len(pkg.CgoFiles) > 0 {
+		addCgoDir(pkg.Dir)
+		for _, inc := range cgoIncludeDirs(pkg) {
+			addCgoDir(inc)
+			*dirs = append(*dirs, inc)
+		}
+	}
+	watching[importpath] = true
+	for _, imp := range pkg.Imports {
+		if !watching[imp] {
+			addDirs(imp, watching, graph, dirs)
+		}
+	}
+}
+
+// structural reports whether a batch added, removed or renamed files, which
+// can change what a package consists of.
+func structural(batch []watch.Event) bool {
+	for _, ev := range batch {
+		if ev.Op&(watch.Create|watch.Remove|watch.Rename) != 0 {
+			return true
+		}
+	}
+	return false
+}
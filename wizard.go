@@ -0,0 +1,98 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// offerSetup asks, on a terminal, whether to write a configuration for the
+// Go project in the current directory, and walks through the choices. It
+// reports whether it wrote --config.
+func offerSetup() bool {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if _, err := os.Stat(*config_file); err == nil {
+		return false
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	p, err := inspect(wd)
+	if err != nil || len(p.mains) == 0 {
+		return false
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	ask := func(question, def string) string {
+		fmt.Printf("%s [%s]: ", question, def)
+		line, err := in.ReadString('\n')
+		if line = strings.TrimSpace(line); line == "" || err != nil {
+			return def
+		}
+		return line
+	}
+	yes := func(question string, def bool) bool {
+		hint := "y/N"
+		if def {
+			hint = "Y/n"
+		}
+		answer := strings.ToLower(ask(question, hint))
+		if answer == strings.ToLower(hint) {
+			return def
+		}
+		return strings.HasPrefix(answer, "y")
+	}
+
+	fmt.Printf("No package given and no %s here.\n", *config_file)
+	if !yes("Set up rerun for this project?", true) {
+		return false
+	}
+	c := p.defaults()
+	if len(p.mains) > 1 {
+		fmt.Println("Main packages:")
+		for i, m := range p.mains {
+			fmt.Printf("  %d) %s\n", i+1, m)
+		}
+		for {
+			n, err := strconv.Atoi(ask("Which one should run?", "1"))
+			if err == nil && n >= 1 && n <= len(p.mains) {
+				c.main = p.mains[n-1]
+				break
+			}
+			fmt.Printf("Pick a number from 1 to %d.\n", len(p.mains))
+		}
+	}
+	def := "none"
+	if len(p.templates) > 0 {
+		def = strings.Join(p.templates, " ")
+	}
+	if dirs := ask("Other directories to watch, separated by spaces", def); dirs != "none" {
+		c.watch = strings.Fields(dirs)
+	}
+	c.test = yes("Run the tests every cycle and only restart when they pass?", p.tests)
+	if spec := ask("Proxy HTTP to hold requests during restarts, as listen->target (e.g. :3000->:8080)", "none"); spec != "none" {
+		if _, _, err := parseProxy(spec); err != nil {
+			fmt.Printf("Not using the proxy: %s\n", err)
+		} else {
+			c.proxy = spec
+		}
+	}
+
+	if err := ioutil.WriteFile(*config_file, p.render(c, "rerun's setup"), 0644); err != nil {
+		log.Printf("error writing %s: %s", *config_file, err)
+		return false
+	}
+	fmt.Printf("wrote %s; starting %s\n", *config_file, c.main)
+	return true
+}
@@ -0,0 +1,39 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var wrap_cmd argsFlag
+
+func init() {
+	flag.Var(&wrap_cmd, "wrap", "Start the program under this command, e.g. \"sudo -E\" or \"dlv exec --headless --listen=:2345\"; {} stands for the binary, which otherwise follows the command")
+}
+
+// wrappedCommand starts the program under --wrap.
+func (s *session) wrappedCommand() *exec.Cmd {
+	var args []string
+	placed := false
+	for _, arg := range wrap_cmd {
+		if strings.Contains(arg, "{}") {
+			arg, placed = strings.Replace(arg, "{}", s.binPath, -1), true
+		}
+		args = append(args, arg)
+	}
+	if !placed {
+		args = append(args, s.binPath)
+	}
+	if name := strings.TrimSuffix(filepath.Base(args[0]), ".exe"); name == "dlv" && len(s.args) > 0 && args[len(args)-1] != "--" {
+		// delve takes the program's arguments after --.
+		args = append(args, "--")
+	}
+	args = append(args, s.args...)
+	return command(args[0], args[1:]...)
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+var wsl struct {
+	once   sync.Once
+	is     bool
+	mounts map[string]string // mount point to file system type
+}
+
+// loadWSL finds out whether we run under WSL and how things are mounted.
+func loadWSL() {
+	data, err := ioutil.ReadFile("/proc/version")
+	if err != nil {
+		return
+	}
+	v := strings.ToLower(string(data))
+	wsl.is = strings.Contains(v, "microsoft") || strings.Contains(v, "wsl")
+	if !wsl.is {
+		return
+	}
+	wsl.mounts = map[string]string{}
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 {
+			wsl.mounts[fields[1]] = fields[2]
+		}
+	}
+}
+
+// needsPolling reports whether dir lives on a WSL mount of a Windows drive,
+// where inotify does not see changes made from the Windows side.
+func needsPolling(dir string) bool {
+	wsl.once.Do(loadWSL)
+	if !wsl.is {
+		return false
+	}
+	best, fstype := "", ""
+	for mnt, t := range wsl.mounts {
+		if (dir == mnt || strings.HasPrefix(dir, strings.TrimSuffix(mnt, "/")+"/")) && len(mnt) > len(best) {
+			best, fstype = mnt, t
+		}
+	}
+	if fstype == "" {
+		return strings.HasPrefix(dir, "/mnt/")
+	}
+	return fstype == "9p" || fstype == "drvfs"
+}